@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AggregationType string
+
+const (
+	AggTerms         AggregationType = "terms"
+	AggDateHistogram AggregationType = "date_histogram"
+	AggMin           AggregationType = "min"
+	AggMax           AggregationType = "max"
+	AggAvg           AggregationType = "avg"
+	AggSum           AggregationType = "sum"
+	AggCardinality   AggregationType = "cardinality"
+)
+
+// defaultTermsSize is the number of terms buckets returned without a Size,
+// the elasticsearch default.
+const defaultTermsSize = 10
+
+// MaxBuckets bounds the number of buckets of one aggregation computed in
+// process, like the elasticsearch search.max_buckets setting. Exceeding it
+// fails with BadRequest.
+var MaxBuckets = 10000
+
+// Aggregation summarizes the objects selected by a SelectionPredicate,
+// either into buckets, which may hold sub-aggregations, or into a single
+// metric value.
+type Aggregation struct {
+	Type  AggregationType
+	Field string
+
+	// Size is the number of terms buckets returned, the most frequent
+	// first. 10 when zero.
+	Size int
+
+	// Interval is the bucket width of a date histogram, a calendar unit
+	// such as "day" or "1M", or a fixed multiple such as "6h".
+	Interval string
+
+	// TimeZone the date histogram buckets start in, an offset such as
+	// "+01:00" or a location name. UTC when empty.
+	TimeZone string
+
+	// Aggregations are computed within every bucket.
+	Aggregations map[string]*Aggregation
+}
+
+func TermsAgg(field string, size int) *Aggregation {
+	return &Aggregation{Type: AggTerms, Field: field, Size: size}
+}
+
+func DateHistogramAgg(field, interval string) *Aggregation {
+	return &Aggregation{Type: AggDateHistogram, Field: field, Interval: interval}
+}
+
+func MinAgg(field string) *Aggregation {
+	return &Aggregation{Type: AggMin, Field: field}
+}
+
+func MaxAgg(field string) *Aggregation {
+	return &Aggregation{Type: AggMax, Field: field}
+}
+
+func AvgAgg(field string) *Aggregation {
+	return &Aggregation{Type: AggAvg, Field: field}
+}
+
+func SumAgg(field string) *Aggregation {
+	return &Aggregation{Type: AggSum, Field: field}
+}
+
+func CardinalityAgg(field string) *Aggregation {
+	return &Aggregation{Type: AggCardinality, Field: field}
+}
+
+// Sub adds a sub-aggregation computed within every bucket of a.
+func (a *Aggregation) Sub(name string, sub *Aggregation) *Aggregation {
+	if a.Aggregations == nil {
+		a.Aggregations = make(map[string]*Aggregation)
+	}
+	a.Aggregations[name] = sub
+	return a
+}
+
+// IsBucket reports whether a yields buckets rather than a metric value.
+func (a *Aggregation) IsBucket() bool {
+	return a.Type == AggTerms || a.Type == AggDateHistogram
+}
+
+// Validate checks a and its sub-aggregations.
+func (a *Aggregation) Validate() error {
+	switch a.Type {
+	case AggTerms, AggMin, AggMax, AggAvg, AggSum, AggCardinality:
+	case AggDateHistogram:
+		if _, err := parseInterval(a.Interval); err != nil {
+			return err
+		}
+		if _, err := loadTimeZone(a.TimeZone); err != nil {
+			return err
+		}
+	default:
+		return NewBadRequestError(fmt.Sprintf("unknown aggregation type %q", a.Type))
+	}
+	if a.Field == "" {
+		return NewBadRequestError(fmt.Sprintf("%s aggregation without a field", a.Type))
+	}
+	if len(a.Aggregations) > 0 && !a.IsBucket() {
+		return NewBadRequestError(fmt.Sprintf("%s aggregation cannot have sub-aggregations", a.Type))
+	}
+
+	for _, sub := range a.Aggregations {
+		if err := sub.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AggregationResult is the outcome of an Aggregation. Metric aggregations
+// set Value, which is nil when no object had a value, bucket aggregations
+// set Buckets.
+type AggregationResult struct {
+	Value   *float64
+	Buckets []*Bucket
+}
+
+// Bucket is one bucket of a bucket aggregation. Key is the term of a terms
+// bucket and the start time.Time of a date histogram bucket.
+type Bucket struct {
+	Key          interface{}
+	DocCount     int64
+	Aggregations map[string]*AggregationResult
+}
+
+// Aggregator is implemented by backends that compute aggregations over the
+// objects List would return for key and sp. The listed page is returned
+// along with the aggregations, unless obj is nil, in which case only the
+// aggregations are computed.
+type Aggregator interface {
+	ListWithAggregations(ctx context.Context, key string, sp *SelectionPredicate, obj interface{},
+		aggs map[string]*Aggregation) ([]interface{}, map[string]*AggregationResult, error)
+}
+
+// AggregateDocs computes aggs in process over decoded JSON documents, for
+// backends without native aggregations. Cardinality is exact.
+func AggregateDocs(docs []map[string]interface{}, aggs map[string]*Aggregation) (map[string]*AggregationResult, error) {
+	results := make(map[string]*AggregationResult, len(aggs))
+	for name, a := range aggs {
+		if err := a.Validate(); err != nil {
+			return nil, err
+		}
+
+		var result *AggregationResult
+		var err error
+		switch a.Type {
+		case AggTerms:
+			result, err = aggregateTerms(docs, a)
+		case AggDateHistogram:
+			result, err = aggregateDateHistogram(docs, a)
+		default:
+			result = aggregateMetric(docs, a)
+		}
+		if err != nil {
+			return nil, err
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+func aggregateTerms(docs []map[string]interface{}, a *Aggregation) (*AggregationResult, error) {
+	type group struct {
+		key  interface{}
+		docs []map[string]interface{}
+	}
+	groups := make(map[string]*group)
+	for _, doc := range docs {
+		seen := make(map[string]bool)
+		anyValue(LookupField(doc, a.Field), func(v interface{}) bool {
+			id := fmt.Sprintf("%T:%v", v, v)
+			if seen[id] {
+				return false
+			}
+			seen[id] = true
+
+			g, ok := groups[id]
+			if !ok {
+				g = &group{key: v}
+				groups[id] = g
+			}
+			g.docs = append(g.docs, doc)
+			return false
+		})
+	}
+
+	list := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].docs) != len(list[j].docs) {
+			return len(list[i].docs) > len(list[j].docs)
+		}
+		if c, ok := compareScalars(list[i].key, list[j].key); ok {
+			return c < 0
+		}
+		return fmt.Sprint(list[i].key) < fmt.Sprint(list[j].key)
+	})
+
+	size := a.Size
+	if size <= 0 {
+		size = defaultTermsSize
+	}
+	if len(list) > size {
+		list = list[:size]
+	}
+	if len(list) > MaxBuckets {
+		return nil, tooManyBuckets(a)
+	}
+
+	result := &AggregationResult{Buckets: make([]*Bucket, 0, len(list))}
+	for _, g := range list {
+		b, err := newBucket(g.key, g.docs, a)
+		if err != nil {
+			return nil, err
+		}
+		result.Buckets = append(result.Buckets, b)
+	}
+	return result, nil
+}
+
+// aggregateDateHistogram buckets documents by date, including the empty
+// buckets between the first and the last one, as elasticsearch does.
+func aggregateDateHistogram(docs []map[string]interface{}, a *Aggregation) (*AggregationResult, error) {
+	iv, _ := parseInterval(a.Interval)
+	loc, _ := loadTimeZone(a.TimeZone)
+
+	groups := make(map[int64][]map[string]interface{})
+	for _, doc := range docs {
+		seen := make(map[int64]bool)
+		anyValue(LookupField(doc, a.Field), func(v interface{}) bool {
+			t, ok := toTime(v, loc)
+			if !ok {
+				return false
+			}
+			key := iv.floor(t.In(loc)).UnixMilli()
+			if !seen[key] {
+				seen[key] = true
+				groups[key] = append(groups[key], doc)
+			}
+			return false
+		})
+	}
+
+	result := &AggregationResult{Buckets: make([]*Bucket, 0)}
+	if len(groups) == 0 {
+		return result, nil
+	}
+
+	var first, last int64
+	started := false
+	for key := range groups {
+		if !started || key < first {
+			first = key
+		}
+		if !started || key > last {
+			last = key
+		}
+		started = true
+	}
+
+	for t := time.UnixMilli(first).In(loc); t.UnixMilli() <= last; t = iv.next(t) {
+		if len(result.Buckets) >= MaxBuckets {
+			return nil, tooManyBuckets(a)
+		}
+		b, err := newBucket(t.UTC(), groups[t.UnixMilli()], a)
+		if err != nil {
+			return nil, err
+		}
+		result.Buckets = append(result.Buckets, b)
+	}
+	return result, nil
+}
+
+func tooManyBuckets(a *Aggregation) error {
+	return NewBadRequestError(fmt.Sprintf("%s aggregation on %s yields more than %d buckets", a.Type, a.Field, MaxBuckets))
+}
+
+func newBucket(key interface{}, docs []map[string]interface{}, a *Aggregation) (*Bucket, error) {
+	b := &Bucket{Key: key, DocCount: int64(len(docs))}
+	if len(a.Aggregations) > 0 {
+		var err error
+		if b.Aggregations, err = AggregateDocs(docs, a.Aggregations); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func aggregateMetric(docs []map[string]interface{}, a *Aggregation) *AggregationResult {
+	var count int
+	var min, max, sum float64
+	distinct := make(map[string]bool)
+	for _, doc := range docs {
+		anyValue(LookupField(doc, a.Field), func(v interface{}) bool {
+			distinct[fmt.Sprintf("%v", v)] = true
+
+			f, ok := toFloat(v)
+			if !ok {
+				return false
+			}
+			if count == 0 || f < min {
+				min = f
+			}
+			if count == 0 || f > max {
+				max = f
+			}
+			sum += f
+			count++
+			return false
+		})
+	}
+
+	var value float64
+	switch a.Type {
+	case AggCardinality:
+		value = float64(len(distinct))
+	case AggSum:
+		value = sum
+	default:
+		if count == 0 {
+			return &AggregationResult{}
+		}
+		switch a.Type {
+		case AggMin:
+			value = min
+		case AggMax:
+			value = max
+		case AggAvg:
+			value = sum / float64(count)
+		}
+	}
+	return &AggregationResult{Value: &value}
+}
+
+// interval is a date histogram interval, either one calendar unit or a fixed
+// duration.
+type interval struct {
+	unit  byte
+	fixed time.Duration
+}
+
+var calendarIntervals = map[string]byte{
+	"year": 'y', "1y": 'y',
+	"quarter": 'q', "1q": 'q',
+	"month": 'M', "1M": 'M',
+	"week": 'w', "1w": 'w',
+	"day": 'd', "1d": 'd',
+	"hour": 'h', "1h": 'h',
+	"minute": 'm', "1m": 'm',
+	"second": 's', "1s": 's',
+}
+
+var fixedUnits = map[byte]time.Duration{
+	'd': 24 * time.Hour,
+	'h': time.Hour,
+	'm': time.Minute,
+	's': time.Second,
+}
+
+func parseInterval(s string) (interval, error) {
+	if unit, ok := calendarIntervals[s]; ok {
+		return interval{unit: unit}, nil
+	}
+
+	if len(s) >= 2 {
+		if d, ok := fixedUnits[s[len(s)-1]]; ok {
+			if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n > 0 {
+				return interval{fixed: time.Duration(n) * d}, nil
+			}
+		}
+	}
+	if strings.TrimSpace(s) == "" {
+		return interval{}, NewBadRequestError("date histogram without an interval")
+	}
+	return interval{}, NewBadRequestError("invalid date histogram interval: " + s)
+}
+
+// floor returns the start of the bucket holding t, in the location of t.
+func (iv interval) floor(t time.Time) time.Time {
+	if iv.fixed == 0 {
+		if iv.unit == 'q' {
+			y, m, _ := t.Date()
+			return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, t.Location())
+		}
+		floor, _ := roundUnit(t, iv.unit)
+		return floor
+	}
+
+	_, offset := t.Zone()
+	ms := t.UnixMilli() + int64(offset)*1000
+	step := iv.fixed.Milliseconds()
+	key := ms / step * step
+	if ms < 0 && ms%step != 0 {
+		key -= step
+	}
+	return time.UnixMilli(key - int64(offset)*1000).In(t.Location())
+}
+
+// next returns the start of the bucket following the one starting at t.
+func (iv interval) next(t time.Time) time.Time {
+	if iv.fixed > 0 {
+		return iv.floor(t.Add(iv.fixed))
+	}
+	if iv.unit == 'q' {
+		return t.AddDate(0, 3, 0)
+	}
+	next, _ := addUnit(t, iv.unit, 1)
+	return iv.floor(next)
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAggregateDocs(t *testing.T) {
+	var docs []map[string]interface{}
+	json.Unmarshal([]byte(`[
+		{"group":"a","size":1,"at":"2024-03-01T10:00:00Z","tags":["x","y"]},
+		{"group":"b","size":2,"at":"2024-03-01T23:00:00Z","tags":["x"]},
+		{"group":"a","size":3,"at":"2024-03-04T08:00:00Z"},
+		{"group":"c","at":1709251200000}
+	]`), &docs)
+
+	results, err := AggregateDocs(docs, map[string]*Aggregation{
+		"groups": TermsAgg("group", 2).Sub("avg", AvgAgg("size")),
+		"tags":   TermsAgg("tags", 0),
+		"days":   DateHistogramAgg("at", "day").Sub("sum", SumAgg("size")),
+		"min":    MinAgg("size"),
+		"max":    MaxAgg("size"),
+		"sum":    SumAgg("size"),
+		"unique": CardinalityAgg("group"),
+		"none":   AvgAgg("missing"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := results["groups"].Buckets
+	if len(groups) != 2 || groups[0].Key != "a" || groups[0].DocCount != 2 || *groups[0].Aggregations["avg"].Value != 2 || groups[1].Key != "b" {
+		t.Fatalf("unexpected groups %s", dumpBuckets(groups))
+	}
+	if tags := results["tags"].Buckets; dumpBuckets(tags) != "x:2 y:1" {
+		t.Fatalf("unexpected tags %s", dumpBuckets(tags))
+	}
+
+	days := results["days"].Buckets
+	if dumpBuckets(days) != "2024-03-01:3 2024-03-02:0 2024-03-03:0 2024-03-04:1" {
+		t.Fatalf("unexpected days %s", dumpBuckets(days))
+	}
+	if *days[0].Aggregations["sum"].Value != 3 || *days[1].Aggregations["sum"].Value != 0 {
+		t.Fatal("unexpected sums of the day buckets")
+	}
+
+	for name, want := range map[string]float64{"min": 1, "max": 3, "sum": 6, "unique": 3} {
+		if v := results[name].Value; v == nil || *v != want {
+			t.Errorf("expect %s %v, but get %v", name, want, v)
+		}
+	}
+	if results["none"].Value != nil {
+		t.Error("expect no average without values")
+	}
+
+	for _, a := range []*Aggregation{
+		{Type: "median", Field: "size"},
+		MinAgg(""),
+		MinAgg("size").Sub("max", MaxAgg("size")),
+		DateHistogramAgg("at", "fortnight"),
+	} {
+		if _, err := AggregateDocs(docs, map[string]*Aggregation{"bad": a}); !IsBadRequest(err) {
+			t.Errorf("expect BadRequest error for %+v, but get %v", a, err)
+		}
+	}
+}
+
+func TestMaxBuckets(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"at": "2024-01-01T00:00:00Z"},
+		{"at": "2024-03-01T00:00:00Z"},
+	}
+	_, err := AggregateDocs(docs, map[string]*Aggregation{"s": DateHistogramAgg("at", "1s")})
+	if !IsBadRequest(err) {
+		t.Fatalf("expect BadRequest error for too many buckets, but get %v", err)
+	}
+
+	results, err := AggregateDocs(docs, map[string]*Aggregation{"d": DateHistogramAgg("at", "1d")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(results["d"].Buckets); n != 61 {
+		t.Fatalf("expect 61 day buckets, but get %d", n)
+	}
+}
+
+func TestDateHistogramInterval(t *testing.T) {
+	plus8, _ := loadTimeZone("+08:00")
+	at := time.Date(2024, 5, 17, 15, 40, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		interval string
+		loc      *time.Location
+		want     string
+	}{
+		{"month", time.UTC, "2024-05-01T00:00:00Z"},
+		{"quarter", time.UTC, "2024-04-01T00:00:00Z"},
+		{"1w", time.UTC, "2024-05-13T00:00:00Z"},
+		{"day", plus8, "2024-05-16T16:00:00Z"},
+		{"6h", time.UTC, "2024-05-17T12:00:00Z"},
+		{"6h", plus8, "2024-05-17T10:00:00Z"},
+		{"90m", time.UTC, "2024-05-17T15:00:00Z"},
+	} {
+		iv, err := parseInterval(tt.interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := iv.floor(at.In(tt.loc)).UTC().Format(time.RFC3339)
+		if got != tt.want {
+			t.Errorf("expect %s for %s in %s, but get %s", tt.want, tt.interval, tt.loc, got)
+		}
+	}
+}
+
+func dumpBuckets(buckets []*Bucket) string {
+	var s string
+	for i, b := range buckets {
+		if i > 0 {
+			s += " "
+		}
+		key := b.Key
+		if t, ok := key.(time.Time); ok {
+			key = t.Format("2006-01-02")
+		}
+		s += fmt.Sprintf("%v:%d", key, b.DocCount)
+	}
+	return s
+}
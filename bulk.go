@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// BulkResult is the outcome of one item of a bulk operation. Err is nil when
+// the item was written.
+type BulkResult struct {
+	Id  string
+	Err error
+}
+
+type BulkOptions struct {
+	// StopOnError aborts the operation at the first failed item. Items that
+	// were still queued are drained from the input channel without being
+	// written.
+	StopOnError bool
+
+	// Results, when set, receives the outcome of every item as soon as it is
+	// known. It is not closed by the store.
+	Results chan<- BulkResult
+}
+
+// BulkSummary reports the outcome of a bulk operation.
+type BulkSummary struct {
+	Succeeded int
+	Failed    []BulkResult
+	Aborted   bool
+}
+
+// Err returns a *BulkError describing the failed items, or nil when every
+// item was written.
+func (s *BulkSummary) Err() error {
+	if s == nil || len(s.Failed) == 0 {
+		return nil
+	}
+	return &BulkError{Succeeded: s.Succeeded, Failed: s.Failed, Aborted: s.Aborted}
+}
+
+// Record adds the outcome of item id to the summary, forwards it to
+// opts.Results and reports whether the operation must stop.
+func (s *BulkSummary) Record(ctx context.Context, opts BulkOptions, id string, err error) bool {
+	result := BulkResult{Id: id, Err: err}
+	if err == nil {
+		s.Succeeded++
+	} else {
+		s.Failed = append(s.Failed, result)
+	}
+
+	if opts.Results != nil {
+		select {
+		case opts.Results <- result:
+		case <-ctx.Done():
+		}
+	}
+
+	if err != nil && opts.StopOnError {
+		s.Aborted = true
+		return true
+	}
+	return false
+}
+
+// BulkError is returned by BulkCreate when some items could not be written.
+type BulkError struct {
+	Succeeded int
+	Failed    []BulkResult
+	Aborted   bool
+}
+
+func (e *BulkError) Error() string {
+	msg := fmt.Sprintf("BulkError: %d of %d items failed", len(e.Failed), len(e.Failed)+e.Succeeded)
+	if e.Aborted {
+		msg += ", aborted"
+	}
+	return fmt.Sprintf("%s, first: %s: %v", msg, e.Failed[0].Id, e.Failed[0].Err)
+}
+
+// IsBulkError returns true if and only if err is a BulkError.
+func IsBulkError(err error) bool {
+	_, ok := err.(*BulkError)
+	return ok
+}
+
+// BulkCreator is implemented by backends that report per-item outcomes of
+// BulkCreate. The returned error is reserved for failures of the operation
+// as a whole, item failures are reported in the summary.
+type BulkCreator interface {
+	BulkCreateWithOptions(ctx context.Context, key string, c chan ChannelObj, ttl uint64, opts BulkOptions) (*BulkSummary, error)
+}
+
+// BulkUpdateObj is one item of BulkUpdate and BulkUpsert.
+type BulkUpdateObj struct {
+	Id string
+
+	// ResourceVersion the object must still have, zero skips the check.
+	ResourceVersion int64
+
+	Data interface{}
+
+	// Insert is the object BulkUpsert creates when Id does not exist yet,
+	// Data when nil.
+	Insert interface{}
+}
+
+// BulkWriter is implemented by backends with bulk updates, upserts and
+// deletes of the objects stored under key/<id>.
+type BulkWriter interface {
+	BulkUpdate(ctx context.Context, key string, c chan BulkUpdateObj, ttl uint64, opts BulkOptions) (*BulkSummary, error)
+	BulkUpsert(ctx context.Context, key string, c chan BulkUpdateObj, ttl uint64, opts BulkOptions) (*BulkSummary, error)
+	BulkDelete(ctx context.Context, key string, ids chan string, opts BulkOptions) (*BulkSummary, error)
+}
+
+// RunBulk applies fn to every item received from c using up to workers
+// goroutines and records the outcomes. fn returns the id of the item and
+// the error it failed with. Once the operation stops, because of
+// opts.StopOnError or ctx, the items still queued in c are drained.
+func RunBulk[T any](ctx context.Context, c chan T, workers int, opts BulkOptions,
+	fn func(ctx context.Context, item T) (string, error)) (*BulkSummary, error) {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	summary := &BulkSummary{}
+	var mu sync.Mutex
+	var stopped bool
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+
+loop:
+	for item := range c {
+		select {
+		case <-ctx.Done():
+			break loop
+		case sem <- struct{}{}:
+		}
+
+		mu.Lock()
+		stop := stopped
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(item T) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			id, err := fn(ctx, item)
+			mu.Lock()
+			if summary.Record(ctx, opts, id, err) {
+				stopped = true
+			}
+			mu.Unlock()
+		}(item)
+	}
+	wg.Wait()
+	Drain(c)
+
+	return summary, ctx.Err()
+}
+
+// Drain discards the items still queued in c, so that the producer of an
+// aborted bulk operation does not block forever.
+func Drain[T any](c chan T) {
+	go func() {
+		for range c {
+		}
+	}()
+}
@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"context"
+)
+
+// Exister is implemented by backends that can tell whether a key exists
+// without transferring the object.
+type Exister interface {
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// Counter is implemented by backends that can count the objects List would
+// return for key and sp without transferring them. Paging fields of sp are
+// ignored.
+type Counter interface {
+	Count(ctx context.Context, key string, sp *SelectionPredicate) (int64, error)
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// EncodeCursor encodes the sort values of the last listed object into the
+// opaque cursor of a SelectionPredicate.
+func EncodeCursor(values []interface{}) (string, error) {
+	body, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(body), nil
+}
+
+// DecodeCursor decodes a cursor returned by EncodeCursor. Numbers are decoded
+// as json.Number so that large integers survive the round trip.
+func DecodeCursor(cursor string) ([]interface{}, error) {
+	body, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, NewBadRequestError("invalid cursor: " + cursor)
+	}
+
+	var values []interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, NewBadRequestError("invalid cursor: " + cursor)
+	}
+	return values, nil
+}
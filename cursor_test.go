@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	cursor, err := EncodeCursor([]interface{}{int64(1<<62 + 1), "b#id", nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := DecodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values[0] != json.Number("4611686018427387905") || values[1] != "b#id" || values[2] != nil {
+		t.Fatalf("expect the sort values to survive the round trip, but get %#v", values)
+	}
+
+	if _, err := DecodeCursor("not a cursor"); !IsBadRequest(err) {
+		t.Fatalf("expect BadRequest error, but get %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the date formats understood in process, tried in order.
+// Layouts without a zone are read in the time zone of the range.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+}
+
+// esDateLayout formats time.Time bounds for elasticsearch, which accepts
+// at most millisecond precision in its default date format.
+const esDateLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// FormatDate formats t the way range bounds are sent to elasticsearch.
+func FormatDate(t time.Time) string {
+	return t.Format(esDateLayout)
+}
+
+// loadTimeZone parses an elasticsearch time zone, either an offset such as
+// "+01:00" or a location name such as "Europe/Paris". The empty zone is UTC.
+func loadTimeZone(tz string) (*time.Location, error) {
+	if tz == "" || tz == "Z" {
+		return time.UTC, nil
+	}
+	if tz[0] == '+' || tz[0] == '-' {
+		t, err := time.Parse("-07:00", tz)
+		if err != nil {
+			return nil, NewBadRequestError("invalid time zone: " + tz)
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(tz, offset), nil
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, NewBadRequestError("invalid time zone: " + tz)
+	}
+	return loc, nil
+}
+
+// parseDate parses a date in one of dateLayouts.
+func parseDate(s string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isDateMath reports whether s is an elasticsearch date math expression.
+func isDateMath(s string) bool {
+	return strings.HasPrefix(s, "now") || strings.Contains(s, "||")
+}
+
+// parseDateMath evaluates an elasticsearch date math expression such as
+// "now-1d/d" or "2024-01-01||+1M". Rounding rounds up to the last
+// millisecond of the unit when roundUp is set, as elasticsearch does for the
+// gt and lte bounds.
+func parseDateMath(expr string, now time.Time, loc *time.Location, roundUp bool) (time.Time, error) {
+	var t time.Time
+	var math string
+	switch {
+	case strings.HasPrefix(expr, "now"):
+		t, math = now.In(loc), expr[len("now"):]
+	case strings.Contains(expr, "||"):
+		idx := strings.Index(expr, "||")
+		var ok bool
+		if t, ok = parseDate(expr[:idx], loc); !ok {
+			return t, NewBadRequestError("invalid date: " + expr[:idx])
+		}
+		math = expr[idx+2:]
+	default:
+		var ok bool
+		if t, ok = parseDate(expr, loc); !ok {
+			return t, NewBadRequestError("invalid date: " + expr)
+		}
+		return t, nil
+	}
+
+	for math != "" {
+		op := math[0]
+		math = math[1:]
+		switch op {
+		case '+', '-':
+			n := 0
+			for n < len(math) && math[n] >= '0' && math[n] <= '9' {
+				n++
+			}
+			amount := 1
+			if n > 0 {
+				amount, _ = strconv.Atoi(math[:n])
+			}
+			if n >= len(math) {
+				return t, NewBadRequestError("missing unit in date math: " + expr)
+			}
+			if op == '-' {
+				amount = -amount
+			}
+
+			var err error
+			if t, err = addUnit(t, math[n], amount); err != nil {
+				return t, NewBadRequestError(fmt.Sprintf("%s in date math: %s", err, expr))
+			}
+			math = math[n+1:]
+		case '/':
+			if math == "" {
+				return t, NewBadRequestError("missing unit in date math: " + expr)
+			}
+
+			floor, err := roundUnit(t, math[0])
+			if err != nil {
+				return t, NewBadRequestError(fmt.Sprintf("%s in date math: %s", err, expr))
+			}
+			t = floor
+			if roundUp {
+				next, _ := addUnit(floor, math[0], 1)
+				t = next.Add(-time.Millisecond)
+			}
+			math = math[1:]
+		default:
+			return t, NewBadRequestError("invalid date math: " + expr)
+		}
+	}
+	return t, nil
+}
+
+func addUnit(t time.Time, unit byte, n int) (time.Time, error) {
+	switch unit {
+	case 'y':
+		return t.AddDate(n, 0, 0), nil
+	case 'M':
+		return t.AddDate(0, n, 0), nil
+	case 'w':
+		return t.AddDate(0, 0, 7*n), nil
+	case 'd':
+		return t.AddDate(0, 0, n), nil
+	case 'h', 'H':
+		return t.Add(time.Duration(n) * time.Hour), nil
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), nil
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), nil
+	}
+	return t, fmt.Errorf("unknown unit %q", unit)
+}
+
+// roundUnit rounds t down to the start of unit in the location of t. Weeks
+// start on Monday.
+func roundUnit(t time.Time, unit byte) (time.Time, error) {
+	y, mo, d := t.Date()
+	loc := t.Location()
+	switch unit {
+	case 'y':
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc), nil
+	case 'M':
+		return time.Date(y, mo, 1, 0, 0, 0, 0, loc), nil
+	case 'w':
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, mo, d-offset, 0, 0, 0, 0, loc), nil
+	case 'd':
+		return time.Date(y, mo, d, 0, 0, 0, 0, loc), nil
+	case 'h', 'H':
+		return time.Date(y, mo, d, t.Hour(), 0, 0, 0, loc), nil
+	case 'm':
+		return time.Date(y, mo, d, t.Hour(), t.Minute(), 0, 0, loc), nil
+	case 's':
+		return time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+	}
+	return t, fmt.Errorf("unknown unit %q", unit)
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateMath(t *testing.T) {
+	now := time.Date(2024, 3, 14, 15, 9, 26, 0, time.UTC)
+	plus8, _ := loadTimeZone("+08:00")
+
+	for _, tt := range []struct {
+		expr    string
+		loc     *time.Location
+		roundUp bool
+		want    string
+	}{
+		{"now", time.UTC, false, "2024-03-14T15:09:26Z"},
+		{"now-1d", time.UTC, false, "2024-03-13T15:09:26Z"},
+		{"now+2h-30m", time.UTC, false, "2024-03-14T16:39:26Z"},
+		{"now/d", time.UTC, false, "2024-03-14T00:00:00Z"},
+		{"now/d", time.UTC, true, "2024-03-14T23:59:59.999Z"},
+		{"now-1M/M", time.UTC, false, "2024-02-01T00:00:00Z"},
+		{"now/w", time.UTC, false, "2024-03-11T00:00:00Z"},
+		{"now/d", plus8, false, "2024-03-14T00:00:00+08:00"},
+		{"2024-01-31||+1d", time.UTC, false, "2024-02-01T00:00:00Z"},
+		{"2024-01-31", plus8, false, "2024-01-31T00:00:00+08:00"},
+	} {
+		got, err := parseDateMath(tt.expr, now, tt.loc, tt.roundUp)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if got.Format(time.RFC3339Nano) != tt.want {
+			t.Errorf("expect %s for %s, but get %s", tt.want, tt.expr, got.Format(time.RFC3339Nano))
+		}
+	}
+
+	for _, expr := range []string{"now-1", "now/x", "now*2", "yesterday||+1d"} {
+		if _, err := parseDateMath(expr, now, time.UTC, false); !IsBadRequest(err) {
+			t.Errorf("expect BadRequest error for %s, but get %v", expr, err)
+		}
+	}
+	if _, err := loadTimeZone("Mars/Olympus"); !IsBadRequest(err) {
+		t.Errorf("expect BadRequest error for an unknown time zone, but get %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package elasticsearch
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// ListWithAggregations runs the search of List with aggs attached. Scrolls
+// and cursors are not supported, the aggregations always cover every
+// matching document.
+func (s *store) ListWithAggregations(ctx context.Context, key string, sp *storage.SelectionPredicate, obj interface{},
+	aggs map[string]*storage.Aggregation) ([]interface{}, map[string]*storage.AggregationResult, error) {
+
+	if obj != nil && reflect.TypeOf(obj).Kind() != reflect.Ptr {
+		return nil, nil, storage.NewBadRequestError("non-pointer " + reflect.TypeOf(obj).String())
+	}
+	if sp == nil {
+		sp = &storage.SelectionPredicate{}
+	}
+	if sp.SearchAfter || sp.ScrollKeepAlive != "" || sp.ScrollId != "" {
+		return nil, nil, storage.NewBadRequestError("aggregations cannot be combined with a scroll or a cursor")
+	}
+
+	key_array := strings.SplitN(key, "/", 4)
+	var idx, typ string
+	if len(key_array) >= 2 {
+		idx = key_array[1]
+	}
+	if len(key_array) >= 3 {
+		typ = key_array[2]
+	}
+
+	us, err := s.searchService(ctx, idx, typ, sp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if obj == nil {
+		us = us.Size(0)
+	}
+	for name, a := range aggs {
+		if err := a.Validate(); err != nil {
+			return nil, nil, err
+		}
+		us = us.Aggregation(name, toElasticAgg(a))
+	}
+
+	resp, err := us.Do(ctx)
+	if err != nil {
+		return nil, nil, convertError(err, key, 0)
+	}
+
+	var list []interface{}
+	if obj != nil {
+		if list, err = parseSearchResult(resp, sp, obj); err != nil {
+			return list, nil, err
+		}
+	}
+	return list, parseAggregations(resp.Aggregations, aggs), nil
+}
+
+func toElasticAgg(a *storage.Aggregation) elastic.Aggregation {
+	switch a.Type {
+	case storage.AggTerms:
+		agg := elastic.NewTermsAggregation().Field(a.Field)
+		if a.Size > 0 {
+			agg = agg.Size(a.Size)
+		}
+		for name, sub := range a.Aggregations {
+			agg = agg.SubAggregation(name, toElasticAgg(sub))
+		}
+		return agg
+	case storage.AggDateHistogram:
+		agg := elastic.NewDateHistogramAggregation().Field(a.Field).Interval(a.Interval)
+		if a.TimeZone != "" {
+			agg = agg.TimeZone(a.TimeZone)
+		}
+		for name, sub := range a.Aggregations {
+			agg = agg.SubAggregation(name, toElasticAgg(sub))
+		}
+		return agg
+	case storage.AggMin:
+		return elastic.NewMinAggregation().Field(a.Field)
+	case storage.AggMax:
+		return elastic.NewMaxAggregation().Field(a.Field)
+	case storage.AggAvg:
+		return elastic.NewAvgAggregation().Field(a.Field)
+	case storage.AggSum:
+		return elastic.NewSumAggregation().Field(a.Field)
+	default:
+		return elastic.NewCardinalityAggregation().Field(a.Field)
+	}
+}
+
+// parseAggregations converts the aggregations of a search response to the
+// results of aggs. Aggregations missing from the response are left out.
+func parseAggregations(resp elastic.Aggregations, aggs map[string]*storage.Aggregation) map[string]*storage.AggregationResult {
+	results := make(map[string]*storage.AggregationResult, len(aggs))
+	for name, a := range aggs {
+		switch a.Type {
+		case storage.AggTerms:
+			items, ok := resp.Terms(name)
+			if !ok {
+				continue
+			}
+			result := &storage.AggregationResult{Buckets: make([]*storage.Bucket, 0, len(items.Buckets))}
+			for _, item := range items.Buckets {
+				result.Buckets = append(result.Buckets, &storage.Bucket{
+					Key:          item.Key,
+					DocCount:     item.DocCount,
+					Aggregations: parseSubAggregations(item.Aggregations, a),
+				})
+			}
+			results[name] = result
+		case storage.AggDateHistogram:
+			items, ok := resp.DateHistogram(name)
+			if !ok {
+				continue
+			}
+			result := &storage.AggregationResult{Buckets: make([]*storage.Bucket, 0, len(items.Buckets))}
+			for _, item := range items.Buckets {
+				result.Buckets = append(result.Buckets, &storage.Bucket{
+					Key:          time.UnixMilli(int64(item.Key)).UTC(),
+					DocCount:     item.DocCount,
+					Aggregations: parseSubAggregations(item.Aggregations, a),
+				})
+			}
+			results[name] = result
+		default:
+			var metric *elastic.AggregationValueMetric
+			var ok bool
+			switch a.Type {
+			case storage.AggMin:
+				metric, ok = resp.Min(name)
+			case storage.AggMax:
+				metric, ok = resp.Max(name)
+			case storage.AggAvg:
+				metric, ok = resp.Avg(name)
+			case storage.AggSum:
+				metric, ok = resp.Sum(name)
+			default:
+				metric, ok = resp.Cardinality(name)
+			}
+			if !ok {
+				continue
+			}
+			results[name] = &storage.AggregationResult{Value: metric.Value}
+		}
+	}
+	return results
+}
+
+func parseSubAggregations(resp elastic.Aggregations, a *storage.Aggregation) map[string]*storage.AggregationResult {
+	if len(a.Aggregations) == 0 {
+		return nil
+	}
+	return parseAggregations(resp, a.Aggregations)
+}
@@ -0,0 +1,230 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// bulkActions is the number of requests sent in one bulk call.
+const bulkActions = 1000
+
+// bulkFlushInterval is how long buffered requests wait for the batch to
+// fill up before they are sent anyway.
+const bulkFlushInterval = time.Second
+
+// encodeDoc returns the JSON body of obj with the expiry for ttl applied.
+func encodeDoc(key string, obj interface{}, ttl uint64, clear bool) (json.RawMessage, error) {
+	doc, err := withExpiry(key, obj, ttl, clear)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, storage.NewInvalidObjError(key, err.Error())
+	}
+	return body, nil
+}
+
+type bulkItem struct {
+	id  string
+	req elastic.BulkableRequest
+}
+
+func (s *store) BulkCreate(ctx context.Context, key string, c chan storage.ChannelObj, ttl uint64) error {
+	summary, err := s.BulkCreateWithOptions(ctx, key, c, ttl, storage.BulkOptions{})
+	if err != nil {
+		return err
+	}
+	return summary.Err()
+}
+
+// BulkCreateWithOptions indexes every object under /index/type/<id>,
+// overwriting documents that already exist.
+func (s *store) BulkCreateWithOptions(ctx context.Context, key string, c chan storage.ChannelObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return runBulk(ctx, s, key, c, opts, func(key_array []string, obj storage.ChannelObj) (string, elastic.BulkableRequest, error) {
+		doc, err := encodeDoc(key+"/"+obj.Id, obj.Data, ttl, false)
+		if err != nil {
+			return obj.Id, nil, err
+		}
+
+		return obj.Id, elastic.NewBulkIndexRequest().
+			Index(key_array[1]).
+			Type(key_array[2]).
+			Id(obj.Id).Doc(doc), nil
+	})
+}
+
+// BulkUpdate applies partial updates to the documents /index/type/<id>.
+func (s *store) BulkUpdate(ctx context.Context, key string, c chan storage.BulkUpdateObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return runBulk(ctx, s, key, c, opts, func(key_array []string, obj storage.BulkUpdateObj) (string, elastic.BulkableRequest, error) {
+		doc, err := encodeDoc(key+"/"+obj.Id, obj.Data, ttl, true)
+		if err != nil {
+			return obj.Id, nil, err
+		}
+
+		req := elastic.NewBulkUpdateRequest().
+			Index(key_array[1]).
+			Type(key_array[2]).
+			Id(obj.Id).Doc(doc)
+		if obj.ResourceVersion != 0 {
+			req = req.Version(obj.ResourceVersion)
+		}
+		return obj.Id, req, nil
+	})
+}
+
+// BulkUpsert applies partial updates to the documents /index/type/<id>,
+// creating the missing ones from Insert.
+func (s *store) BulkUpsert(ctx context.Context, key string, c chan storage.BulkUpdateObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return runBulk(ctx, s, key, c, opts, func(key_array []string, obj storage.BulkUpdateObj) (string, elastic.BulkableRequest, error) {
+		insert_obj := obj.Insert
+		if insert_obj == nil {
+			insert_obj = obj.Data
+		}
+
+		doc, err := encodeDoc(key+"/"+obj.Id, obj.Data, ttl, true)
+		if err != nil {
+			return obj.Id, nil, err
+		}
+		insert, err := encodeDoc(key+"/"+obj.Id, insert_obj, ttl, false)
+		if err != nil {
+			return obj.Id, nil, err
+		}
+
+		req := elastic.NewBulkUpdateRequest().
+			Index(key_array[1]).
+			Type(key_array[2]).
+			Id(obj.Id).Doc(doc).Upsert(insert)
+		if obj.ResourceVersion != 0 {
+			req = req.Version(obj.ResourceVersion)
+		}
+		return obj.Id, req, nil
+	})
+}
+
+// BulkDelete deletes the documents /index/type/<id>.
+func (s *store) BulkDelete(ctx context.Context, key string, ids chan string, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return runBulk(ctx, s, key, ids, opts, func(key_array []string, id string) (string, elastic.BulkableRequest, error) {
+		return id, elastic.NewBulkDeleteRequest().
+			Index(key_array[1]).
+			Type(key_array[2]).
+			Id(id), nil
+	})
+}
+
+// runBulk sends the requests built from the items of c in batches of
+// bulkActions, or every bulkFlushInterval while items trickle in. Items that
+// fail to build are recorded without being sent.
+// With StopOnError the rest of the failing batch may still have been
+// written; their outcomes are recorded as well.
+func runBulk[T any](ctx context.Context, s *store, key string, c chan T, opts storage.BulkOptions,
+	build func(key_array []string, item T) (string, elastic.BulkableRequest, error)) (*storage.BulkSummary, error) {
+
+	key_array := strings.SplitN(key, "/", 4)
+	if len(key_array) < 3 {
+		storage.Drain(c)
+		return nil, storage.NewBadRequestError("the key must match \"/index/type\" pattern")
+	}
+
+	summary := &storage.BulkSummary{}
+	batch := make([]bulkItem, 0, bulkActions)
+	ticker := time.NewTicker(bulkFlushInterval)
+	defer ticker.Stop()
+	for {
+		var item T
+		var ok bool
+		select {
+		case <-ctx.Done():
+			storage.Drain(c)
+			return summary, ctx.Err()
+		case <-ticker.C:
+			stop, err := s.flushBulk(ctx, key_array, batch, summary, opts)
+			batch = batch[:0]
+			if stop || err != nil {
+				storage.Drain(c)
+				return summary, err
+			}
+			continue
+		case item, ok = <-c:
+		}
+		if !ok {
+			break
+		}
+
+		id, req, err := build(key_array, item)
+		if err != nil {
+			if summary.Record(ctx, opts, id, err) {
+				// the batched items precede the failing one, so they are still sent
+				storage.Drain(c)
+				_, err := s.flushBulk(ctx, key_array, batch, summary, opts)
+				return summary, err
+			}
+			continue
+		}
+
+		batch = append(batch, bulkItem{id: id, req: req})
+		if len(batch) < bulkActions {
+			continue
+		}
+
+		stop, err := s.flushBulk(ctx, key_array, batch, summary, opts)
+		batch = batch[:0]
+		if stop || err != nil {
+			storage.Drain(c)
+			return summary, err
+		}
+	}
+
+	_, err := s.flushBulk(ctx, key_array, batch, summary, opts)
+	return summary, err
+}
+
+// flushBulk sends batch and records the outcome of every item. It reports
+// whether the operation must stop because of opts.StopOnError; an error is
+// returned when the bulk request itself failed.
+func (s *store) flushBulk(ctx context.Context, key_array []string, batch []bulkItem, summary *storage.BulkSummary, opts storage.BulkOptions) (bool, error) {
+	if len(batch) == 0 {
+		return false, nil
+	}
+
+	bs := elastic.NewBulkService(s.client)
+	for _, item := range batch {
+		bs = bs.Add(item.req)
+	}
+
+	prefix := "/" + key_array[1] + "/" + key_array[2] + "/"
+	resp, err := bs.Do(ctx)
+	if err != nil {
+		err = convertError(err, prefix, 0)
+		for _, item := range batch {
+			summary.Record(ctx, opts, item.id, err)
+		}
+		return true, err
+	}
+
+	var stop bool
+	for i, item := range batch {
+		var itemErr error
+		if i >= len(resp.Items) {
+			itemErr = storage.NewInternalError("missing bulk response item")
+		} else {
+			for _, r := range resp.Items[i] {
+				if r.Error != nil || r.Status >= 300 {
+					itemErr = convertError(&elastic.Error{Status: r.Status, Details: r.Error}, prefix+item.id, 0)
+				}
+			}
+		}
+
+		if summary.Record(ctx, opts, item.id, itemErr) {
+			stop = true
+		}
+	}
+	return stop, nil
+}
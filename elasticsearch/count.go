@@ -0,0 +1,109 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// Exists reports whether the document stored under key exists. A HEAD
+// request cannot see the source, so it would report documents that expired
+// but were not reaped yet. Any document may carry an expiry, hence Exists
+// always issues a GET whose source is filtered to the expiry field, which
+// keeps the transferred body to a few bytes.
+func (s *store) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.expiry(ctx, key)
+	if storage.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// TTL reads the remaining ttl of the document stored under key from its
+// expiry field.
+func (s *store) TTL(ctx context.Context, key string) (uint64, error) {
+	at, err := s.expiry(ctx, key)
+	if err != nil || at == 0 {
+		return 0, err
+	}
+	return storage.RemainingTTL(time.Unix(0, at*int64(time.Millisecond))), nil
+}
+
+// expiry fetches the expiry field alone of the document stored under key and
+// returns it, zero when the document never expires. Missing and expired
+// documents give KeyNotFound.
+func (s *store) expiry(ctx context.Context, key string) (int64, error) {
+	key_array := strings.SplitN(key, "/", 4)
+	if len(key_array) != 4 {
+		return 0, storage.NewBadRequestError("the key must match \"/index/type/id\" pattern")
+	}
+
+	resp, err := elastic.NewGetService(s.client).
+		Index(key_array[1]).
+		Type(key_array[2]).
+		Id(key_array[3]).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include(ExpireAtField)).
+		Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return 0, storage.NewKeyNotFoundError(key, 0)
+		}
+		return 0, convertError(err, key, 0)
+	}
+	if !resp.Found {
+		return 0, storage.NewKeyNotFoundError(key, 0)
+	}
+	if resp.Source == nil {
+		return 0, nil
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(*resp.Source, &doc); err != nil {
+		return 0, storage.NewInvalidObjError(key, err.Error())
+	}
+	at, _ := doc[ExpireAtField].(float64)
+	if at > 0 && int64(at) <= toMillis(time.Now()) {
+		return 0, storage.NewKeyNotFoundError(key, 0)
+	}
+	return int64(at), nil
+}
+
+// Count counts the live documents matching sp with a _count request.
+func (s *store) Count(ctx context.Context, key string, sp *storage.SelectionPredicate) (int64, error) {
+	key_array := strings.SplitN(key, "/", 4)
+	var idx, typ string
+	if len(key_array) >= 2 {
+		idx = key_array[1]
+	}
+	if len(key_array) >= 3 {
+		typ = key_array[2]
+	}
+
+	cs := elastic.NewCountService(s.client)
+	if idx != "" {
+		cs = cs.Index(idx)
+	}
+	if typ != "" {
+		cs = cs.Type(typ)
+	}
+
+	var query elastic.Query
+	if sp != nil && sp.Keyword != nil {
+		var err error
+		query, err = getQueryByKeyword(sp.Keyword)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	count, err := cs.Query(notExpired(query)).Do(ctx)
+	if err != nil {
+		return 0, convertError(err, key, 0)
+	}
+	return count, nil
+}
@@ -0,0 +1,49 @@
+package elasticsearch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// convertError classifies an error returned by elastic into a storage error
+// for key. The elastic error stays reachable through errors.As. Context
+// errors are returned as is.
+func convertError(err error, key string, rv int64) error {
+	if err == nil {
+		return nil
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded || elastic.IsContextErr(err) {
+		return err
+	}
+
+	switch {
+	case elastic.IsNotFound(err):
+		return storage.NewKeyNotFoundError(key, rv).WithCause(err)
+	case elastic.IsConflict(err):
+		return storage.NewResourceVersionConflictsError(key, rv).WithCause(err)
+	case elastic.IsStatusCode(err, http.StatusBadRequest):
+		return storage.NewBadRequestError(err.Error()).WithCause(err)
+	case isUnreachable(err):
+		return storage.NewUnreachableError(key, rv).WithCause(err)
+	}
+
+	return storage.InternalError{Reason: err.Error(), Err: err}
+}
+
+func isUnreachable(err error) bool {
+	if elastic.IsConnErr(err) {
+		return true
+	}
+
+	switch err.(type) {
+	case *url.Error, *net.OpError, net.Error:
+		return true
+	}
+	return false
+}
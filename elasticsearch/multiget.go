@@ -0,0 +1,91 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// GetMany fetches the documents stored under keys with a single _mget
+// request. Keys must match the "/index/type/id" pattern.
+func (s *store) GetMany(ctx context.Context, keys []string, newObj func() interface{}) (map[string]interface{}, map[string]error) {
+	objs := make(map[string]interface{}, len(keys))
+	errs := make(map[string]error)
+
+	ms := elastic.NewMgetService(s.client)
+	fsc := fetchSource(storage.ProjectionFrom(ctx))
+	requested := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		key_array := strings.SplitN(key, "/", 4)
+		if len(key_array) != 4 {
+			errs[key] = storage.NewBadRequestError("the key must match \"/index/type/id\" pattern")
+			continue
+		}
+		item := elastic.NewMultiGetItem().
+			Index(key_array[1]).
+			Type(key_array[2]).
+			Id(key_array[3])
+		if fsc != nil {
+			item = item.FetchSource(fsc)
+		}
+		ms = ms.Add(item)
+		requested = append(requested, key)
+	}
+	if len(requested) == 0 {
+		return objs, errs
+	}
+
+	resp, err := ms.Do(ctx)
+	if err != nil {
+		err = convertError(err, "", 0)
+		for _, key := range requested {
+			errs[key] = err
+		}
+		return objs, errs
+	}
+
+	for i, key := range requested {
+		if i >= len(resp.Docs) {
+			errs[key] = storage.NewInternalError("missing _mget response document")
+			continue
+		}
+
+		doc := resp.Docs[i]
+		if doc.Error != nil {
+			errs[key] = storage.InternalError{Reason: doc.Error.Reason, Err: &elastic.Error{Details: doc.Error}}
+			continue
+		}
+		if !doc.Found || doc.Source == nil {
+			errs[key] = storage.NewKeyNotFoundError(key, 0)
+			continue
+		}
+
+		source, expired := stripExpiry(*doc.Source)
+		if expired {
+			errs[key] = storage.NewKeyNotFoundError(key, 0)
+			continue
+		}
+
+		obj := newObj()
+		if err := json.Unmarshal(source, obj); err != nil {
+			errs[key] = storage.NewInvalidObjError(key, err.Error())
+			continue
+		}
+		if doc.Version != nil {
+			storage.SetResourceVersion(obj, *doc.Version)
+		}
+		objs[key] = obj
+	}
+
+	return objs, errs
+}
@@ -0,0 +1,46 @@
+package elasticsearch
+
+import (
+	"context"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// fetchSource converts p to _source filtering, nil when whole documents are
+// fetched. ExpireAtField is always fetched so that expired documents are
+// still recognized.
+func fetchSource(p storage.Projection) *elastic.FetchSourceContext {
+	if p.IsEmpty() {
+		return nil
+	}
+
+	fsc := elastic.NewFetchSourceContext(true)
+	if len(p.Includes) > 0 {
+		fsc = fsc.Include(append(p.Includes[:len(p.Includes):len(p.Includes)], ExpireAtField)...)
+	}
+	if len(p.Excludes) > 0 {
+		fsc = fsc.Exclude(p.Excludes...)
+	}
+	return fsc
+}
+
+// listProjection returns the projection of a List. The excludes of the
+// deprecated "excludes" context value are still honored.
+func listProjection(ctx context.Context, sp *storage.SelectionPredicate) storage.Projection {
+	p := sp.Projection()
+	if excludes, ok := ctx.Value("excludes").([]string); ok {
+		p.Excludes = append(p.Excludes[:len(p.Excludes):len(p.Excludes)], excludes...)
+	}
+	return p
+}
+
+// listSource returns the _source filtering of a List, which fetches no
+// _source at all for KeyOnly predicates.
+func listSource(ctx context.Context, sp *storage.SelectionPredicate) *elastic.FetchSourceContext {
+	if sp != nil && sp.KeyOnly {
+		return elastic.NewFetchSourceContext(false)
+	}
+	return fetchSource(listProjection(ctx, sp))
+}
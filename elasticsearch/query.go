@@ -0,0 +1,94 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// toElasticQuery compiles a storage.Query to an elasticsearch query.
+func toElasticQuery(q storage.Query) (elastic.Query, error) {
+	switch v := q.(type) {
+	case nil:
+		return elastic.NewMatchAllQuery(), nil
+	case *storage.EqQuery:
+		return elastic.NewTermQuery(v.Field, v.Value), nil
+	case *storage.InQuery:
+		return elastic.NewTermsQuery(v.Field, v.Values...), nil
+	case *storage.RangeQuery:
+		rq := elastic.NewRangeQuery(v.Field)
+		if v.Gt != nil {
+			rq = rq.Gt(rangeBound(v.Gt))
+		}
+		if v.Gte != nil {
+			rq = rq.Gte(rangeBound(v.Gte))
+		}
+		if v.Lt != nil {
+			rq = rq.Lt(rangeBound(v.Lt))
+		}
+		if v.Lte != nil {
+			rq = rq.Lte(rangeBound(v.Lte))
+		}
+		if v.Format != "" {
+			rq = rq.Format(v.Format)
+		}
+		if v.TimeZone != "" {
+			rq = rq.TimeZone(v.TimeZone)
+		}
+		return rq, nil
+	case *storage.PrefixQuery:
+		return elastic.NewPrefixQuery(v.Field, v.Prefix), nil
+	case *storage.ExistsQuery:
+		return elastic.NewExistsQuery(v.Field), nil
+	case *storage.MatchQuery:
+		return elastic.NewMatchQuery(v.Field, v.Text), nil
+	case *storage.QueryStringQuery:
+		return elastic.NewQueryStringQuery(v.Query), nil
+	case *storage.RawQuery:
+		return NewInterfaceQuery(v.Source), nil
+	case *storage.AndQuery:
+		queries, err := toElasticQueries(v.Queries)
+		if err != nil {
+			return nil, err
+		}
+		return elastic.NewBoolQuery().Must(queries...), nil
+	case *storage.OrQuery:
+		queries, err := toElasticQueries(v.Queries)
+		if err != nil {
+			return nil, err
+		}
+		return elastic.NewBoolQuery().Should(queries...).MinimumNumberShouldMatch(1), nil
+	case *storage.NotQuery:
+		query, err := toElasticQuery(v.Query)
+		if err != nil {
+			return nil, err
+		}
+		return elastic.NewBoolQuery().MustNot(query), nil
+	default:
+		return nil, storage.NewBadRequestError(fmt.Sprintf("unsupported query %T", q))
+	}
+}
+
+// rangeBound formats time.Time bounds in a format accepted by the default
+// date mapping, other bounds, date math included, are sent as they are.
+func rangeBound(v interface{}) interface{} {
+	if t, ok := v.(time.Time); ok {
+		return storage.FormatDate(t)
+	}
+	return v
+}
+
+func toElasticQueries(queries []storage.Query) ([]elastic.Query, error) {
+	list := make([]elastic.Query, len(queries))
+	for i, q := range queries {
+		query, err := toElasticQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = query
+	}
+	return list, nil
+}
@@ -0,0 +1,89 @@
+package elasticsearch
+
+import (
+	"context"
+	"io"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// defaultPageSize is the page size of cursor paging without a Limit, the
+// elasticsearch default.
+const defaultPageSize = 10
+
+// tiebreakField makes the sort order of cursor paging total. Elasticsearch 5
+// has no doc values on _id, _uid ("type#id") is its sortable equivalent.
+const tiebreakField = "_uid"
+
+// listBySearchAfter lists one page of a cursor paged listing with a
+// search_after request.
+func (s *store) listBySearchAfter(ctx context.Context, idx, tpe string, sp *storage.SelectionPredicate) (resp *elastic.SearchResult, err error) {
+	if sp.EOF {
+		sp.Cursor = ""
+		return nil, io.EOF
+	}
+	if sp.From > 0 {
+		return nil, storage.NewBadRequestError("from must be zero when paging with a cursor")
+	}
+
+	us := elastic.NewSearchService(s.client).Index(idx).Version(true)
+	if tpe != "" {
+		us = us.Type(tpe)
+	}
+
+	size := sp.Limit
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	us = us.Size(size)
+
+	var query elastic.Query
+	if sp.Keyword != nil {
+		query, err = getQueryByKeyword(sp.Keyword)
+		if err != nil {
+			return
+		}
+	}
+	us = us.Query(notExpired(query))
+
+	list, err := sorters(sp.Sort)
+	if err != nil {
+		return
+	}
+	us = us.SortBy(append(list, elastic.NewFieldSort(tiebreakField).Asc())...)
+
+	if fsc := listSource(ctx, sp); fsc != nil {
+		us = us.FetchSourceContext(fsc)
+	}
+
+	if sp.Cursor != "" {
+		var values []interface{}
+		values, err = storage.DecodeCursor(sp.Cursor)
+		if err != nil {
+			return
+		}
+		us = us.SearchAfter(values...)
+	}
+
+	resp, err = us.Do(ctx)
+	if err != nil {
+		return resp, convertError(err, "/"+idx, 0)
+	}
+
+	hits := 0
+	if resp.Hits != nil {
+		hits = len(resp.Hits.Hits)
+	}
+	if hits < size {
+		sp.EOF = true
+	}
+	if hits > 0 {
+		sp.Cursor, err = storage.EncodeCursor(resp.Hits.Hits[hits-1].Sort)
+		if err != nil {
+			return resp, storage.NewInternalError(err.Error())
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,25 @@
+package elasticsearch
+
+import (
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// sorters converts the sort fields of a SelectionPredicate to field sorts.
+// Missing values are passed through, "_first" and "_last" included.
+func sorters(sorts []storage.SortField) ([]elastic.Sorter, error) {
+	list := make([]elastic.Sorter, 0, len(sorts))
+	for _, sf := range sorts {
+		if sf.Field == "" {
+			return nil, storage.NewBadRequestError("sort field must not be empty")
+		}
+
+		fs := elastic.NewFieldSort(sf.Field).Order(!sf.Descending)
+		if sf.Missing != nil {
+			fs = fs.Missing(sf.Missing)
+		}
+		list = append(list, fs)
+	}
+	return list, nil
+}
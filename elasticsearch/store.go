@@ -3,7 +3,6 @@ package elasticsearch
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -18,6 +17,9 @@ import (
 
 var (
 	HttpClient *http.Client
+
+	// WatchInterval is how often Watch polls the index for changes.
+	WatchInterval = 2 * time.Second
 )
 
 func init() {
@@ -62,41 +64,60 @@ func (s *store) Create(ctx context.Context, key string, obj interface{}, ttl uin
 		return storage.NewBadRequestError("the key must match \"/index/type/id\" pattern")
 	}
 
-	_, err := elastic.NewIndexService(s.client).
+	obj, err := withExpiry(key, obj, ttl, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = elastic.NewIndexService(s.client).
 		BodyJson(obj).
 		Index(key_array[1]).
 		Type(key_array[2]).
 		Id(key_array[3]).
+		OpType("create").
 		Do(ctx)
 
 	if err != nil {
-		return storage.NewInternalError(err.Error())
+		if elastic.IsConflict(err) {
+			return s.replaceExpired(ctx, key, key_array, obj)
+		}
+		return convertError(err, key, 0)
 	}
 
 	return nil
 }
 
-func (s *store) BulkCreate(ctx context.Context, key string, c chan storage.ChannelObj, ttl uint64) error {
-	key_array := strings.SplitN(key, "/", 4)
-	if len(key_array) != 4 {
-		return storage.NewBadRequestError("the key must match \"/index/type/id\" pattern")
-	}
-
-	bp, err := elastic.NewBulkProcessorService(s.client).
-		BulkActions(1000).
-		FlushInterval(time.Second).Do(ctx)
+// replaceExpired overwrites the document that made a create request fail if
+// it has expired but was not reaped yet, and reports KeyExists otherwise.
+func (s *store) replaceExpired(ctx context.Context, key string, key_array []string, obj interface{}) error {
+	resp, err := elastic.NewGetService(s.client).
+		Index(key_array[1]).
+		Type(key_array[2]).
+		Id(key_array[3]).
+		Do(ctx)
 	if err != nil {
-		return storage.NewInternalError(err.Error())
+		return convertError(err, key, 0)
+	}
+	if resp.Source == nil || resp.Version == nil {
+		return storage.NewKeyExistsError(key, 0)
+	}
+	if _, expired := stripExpiry(*resp.Source); !expired {
+		return storage.NewKeyExistsError(key, *resp.Version)
 	}
-	defer bp.Close()
 
-	for item := range c {
-		bp.Add(elastic.NewBulkIndexRequest().
-			Index(key_array[1]).
-			Type(key_array[2]).
-			Id(item.Id).Doc(item.Data))
+	_, err = elastic.NewIndexService(s.client).
+		BodyJson(obj).
+		Index(key_array[1]).
+		Type(key_array[2]).
+		Id(key_array[3]).
+		Version(*resp.Version).
+		Do(ctx)
+	if err != nil {
+		if elastic.IsConflict(err) {
+			return storage.NewKeyExistsError(key, *resp.Version).WithCause(err)
+		}
+		return convertError(err, key, 0)
 	}
-	bp.Flush()
 
 	return nil
 }
@@ -107,6 +128,11 @@ func (s *store) Update(ctx context.Context, key string, resourceVersion int64, o
 		return storage.NewBadRequestError("the key must match \"/index/type/id\" pattern")
 	}
 
+	obj, err := withExpiry(key, obj, ttl, true)
+	if err != nil {
+		return err
+	}
+
 	us := elastic.NewUpdateService(s.client).
 		Doc(obj).
 		Index(key_array[1]).
@@ -117,12 +143,9 @@ func (s *store) Update(ctx context.Context, key string, resourceVersion int64, o
 		us = us.Version(resourceVersion)
 	}
 
-	_, err := us.Do(ctx)
+	_, err = us.Do(ctx)
 	if err != nil {
-		if elastic.IsNotFound(err) {
-			return storage.NewKeyNotFoundError(key, resourceVersion)
-		}
-		return storage.NewInternalError(err.Error())
+		return convertError(err, key, resourceVersion)
 	}
 
 	return nil
@@ -137,6 +160,14 @@ func (s *store) Upsert(ctx context.Context, key string, resourceVersion int64, u
 	if insert_obj == nil {
 		insert_obj = update_obj
 	}
+	update_obj, err := withExpiry(key, update_obj, ttl, true)
+	if err != nil {
+		return err
+	}
+	insert_obj, err = withExpiry(key, insert_obj, ttl, false)
+	if err != nil {
+		return err
+	}
 
 	us := elastic.NewUpdateService(s.client).
 		Doc(update_obj).
@@ -149,12 +180,9 @@ func (s *store) Upsert(ctx context.Context, key string, resourceVersion int64, u
 		us = us.Version(resourceVersion)
 	}
 
-	_, err := us.Do(ctx)
+	_, err = us.Do(ctx)
 	if err != nil {
-		if elastic.IsNotFound(err) {
-			return storage.NewKeyNotFoundError(key, resourceVersion)
-		}
-		return storage.NewInternalError(err.Error())
+		return convertError(err, key, resourceVersion)
 	}
 
 	return nil
@@ -173,18 +201,23 @@ func (s *store) Get(ctx context.Context, key string, out interface{}) error {
 		Index(key_array[1]).
 		Type(key_array[2]).
 		Id(key_array[3])
+	if fsc := fetchSource(storage.ProjectionFrom(ctx)); fsc != nil {
+		us = us.FetchSourceContext(fsc)
+	}
 
 	resp, err := us.Do(ctx)
 	if err != nil {
-		if elastic.IsNotFound(err) {
-			return storage.NewKeyNotFoundError(key, 0)
-		}
-		return storage.NewInternalError(err.Error())
+		return convertError(err, key, 0)
+	}
+
+	source, expired := stripExpiry(*resp.Source)
+	if expired {
+		return storage.NewKeyNotFoundError(key, 0)
 	}
 
 	if out != nil {
-		err = json.Unmarshal(*resp.Source, out)
-		setResourceVersion(out, *resp.Version)
+		err = json.Unmarshal(source, out)
+		storage.SetResourceVersion(out, *resp.Version)
 	}
 
 	return err
@@ -203,10 +236,7 @@ func (s *store) Delete(ctx context.Context, key string, out interface{}) error {
 
 	_, err := us.Do(ctx)
 	if err != nil {
-		if elastic.IsNotFound(err) {
-			return storage.NewKeyNotFoundError(key, 0)
-		}
-		return storage.NewInternalError(err.Error())
+		return convertError(err, key, 0)
 	}
 
 	return err
@@ -241,15 +271,28 @@ func (s *store) DeleteByQuery(ctx context.Context, key string, keyword interface
 
 	resp, err := us.Do(ctx)
 	if err != nil && !elastic.IsConflict(err) {
-		return 0, 0, storage.NewInternalError(err.Error())
+		return 0, 0, convertError(err, key, 0)
+	}
+	if resp == nil {
+		return 0, 0, convertError(err, key, 0)
 	}
 
 	return resp.Deleted, resp.VersionConflicts, nil
 }
 
+// List searches the documents below key. KeyOnly predicates fetch no
+// _source and return the keys of the documents, such as "/index/type/id".
 func (s *store) List(ctx context.Context, key string, sp *storage.SelectionPredicate, obj interface{}) ([]interface{}, error) {
-	if reflect.TypeOf(obj).Kind() != reflect.Ptr {
-		return nil, storage.NewBadRequestError("non-pointer " + reflect.TypeOf(obj).String())
+	if sp == nil || !sp.KeyOnly {
+		if obj == nil {
+			return nil, storage.NewBadRequestError("non-pointer")
+		}
+		if reflect.TypeOf(obj).Kind() != reflect.Ptr {
+			return nil, storage.NewBadRequestError("non-pointer " + reflect.TypeOf(obj).String())
+		}
+	}
+	if sp != nil && sp.Delimiter != "" {
+		return nil, storage.NewBadRequestError("the elasticsearch store does not support directory listings")
 	}
 
 	key_array := strings.SplitN(key, "/", 4)
@@ -265,52 +308,80 @@ func (s *store) List(ctx context.Context, key string, sp *storage.SelectionPredi
 	var err error
 	var resp *elastic.SearchResult
 	if sp != nil {
-		if sp.ScrollKeepAlive != "" || sp.ScrollId != "" {
+		if sp.SearchAfter {
+			if sp.ScrollKeepAlive != "" || sp.ScrollId != "" {
+				return nil, storage.NewBadRequestError("cursor paging cannot be combined with a scroll")
+			}
+			resp, err = s.listBySearchAfter(ctx, idx, typ, sp)
+		} else if sp.ScrollKeepAlive != "" || sp.ScrollId != "" {
 			resp, err = s.listByScroll(ctx, idx, typ, sp)
 		} else {
-			resp, err = s.listBySearch(ctx, idx, typ, sp.Keyword, sp.From, sp.Limit)
+			resp, err = s.listBySearch(ctx, idx, typ, sp)
 		}
 	} else {
-		resp, err = s.listBySearch(ctx, idx, typ, "", 0, 0)
+		resp, err = s.listBySearch(ctx, idx, typ, &storage.SelectionPredicate{})
+	}
+	if err != nil {
+		return nil, err
 	}
+
+	return parseSearchResult(resp, sp, obj)
+}
+
+func (s *store) listBySearch(ctx context.Context, idx, tpe string, sp *storage.SelectionPredicate) (resp *elastic.SearchResult, err error) {
+	us, err := s.searchService(ctx, idx, tpe, sp)
 	if err != nil {
 		return nil, err
 	}
 
-	return parseSearchResult(resp, obj)
+	resp, err = us.Do(ctx)
+	return resp, convertError(err, "/"+idx, 0)
 }
 
-func (s *store) listBySearch(ctx context.Context, idx, tpe string, keyword interface{}, from, size int) (resp *elastic.SearchResult, err error) {
-	us := elastic.NewSearchService(s.client).Index(idx).Version(true)
+// searchService builds the search of a List without a scroll or cursor.
+func (s *store) searchService(ctx context.Context, idx, tpe string, sp *storage.SelectionPredicate) (us *elastic.SearchService, err error) {
+	us = elastic.NewSearchService(s.client).Index(idx).Version(true)
 	if tpe != "" {
 		us = us.Type(tpe)
 	}
-	//fmt.Printf("%s %s %v %d %d\n", idx, tpe, keyword, from, size)
 
 	// from and size
-	if size > 0 {
-		us = us.Size(size)
+	if sp.Limit > 0 {
+		us = us.Size(sp.Limit)
 	}
-	if from > 0 {
-		if from+size > 10000 {
+	if sp.From > 0 {
+		if sp.From+sp.Limit > 10000 {
 			err = storage.NewBadRequestError("from+size parameter must be less than 10000")
 			return
 		}
-		us = us.From(from)
+		us = us.From(sp.From)
 	}
 
-	if keyword != nil {
-		var query elastic.Query
-		query, err = getQueryByKeyword(keyword)
+	var query elastic.Query
+	if sp.Keyword != nil {
+		query, err = getQueryByKeyword(sp.Keyword)
 		if err != nil {
 			return
 		}
-		if query != nil {
-			us = us.Query(query)
+	}
+	us = us.Query(notExpired(query))
+
+	// sort
+	if len(sp.Sort) > 0 {
+		var list []elastic.Sorter
+		list, err = sorters(sp.Sort)
+		if err != nil {
+			return
 		}
+		us = us.SortBy(list...)
+	}
+
+	// source filter
+	if fsc := listSource(ctx, sp); fsc != nil {
+		us = us.FetchSourceContext(fsc)
 	}
 
-	return us.Do(ctx)
+	return us, nil
 }
 
 func (s *store) listByScroll(ctx context.Context, idx, tpe string, sp *storage.SelectionPredicate) (resp *elastic.SearchResult, err error) {
@@ -331,24 +402,28 @@ func (s *store) listByScroll(ctx context.Context, idx, tpe string, sp *storage.S
 	}
 
 	// query
+	var query elastic.Query
 	if sp.Keyword != nil {
-		var query elastic.Query
 		query, err = getQueryByKeyword(sp.Keyword)
 		if err != nil {
 			return
 		}
-		if query != nil {
-			ss = ss.Query(query)
+	}
+	ss = ss.Query(notExpired(query))
+
+	// sort
+	if len(sp.Sort) > 0 {
+		var list []elastic.Sorter
+		list, err = sorters(sp.Sort)
+		if err != nil {
+			return
 		}
+		ss = ss.SortBy(list...)
 	}
 
 	// source filter
-	excludes := ctx.Value("excludes")
-	if excludes != nil {
-		switch v := excludes.(type) {
-		case []string:
-			ss = ss.FetchSourceContext(elastic.NewFetchSourceContext(true).Exclude(v...))
-		}
+	if fsc := listSource(ctx, sp); fsc != nil {
+		ss = ss.FetchSourceContext(fsc)
 	}
 
 	ss = ss.Scroll(sp.ScrollKeepAlive)
@@ -359,85 +434,45 @@ func (s *store) listByScroll(ctx context.Context, idx, tpe string, sp *storage.S
 			sp.ScrollId = ""
 			return resp, nil
 		} else {
-			return resp, err
+			return resp, convertError(err, "/"+idx, 0)
 		}
 	}
 	sp.ScrollId = resp.ScrollId
 	return resp, nil
 }
 
-func parseSearchResult(resp *elastic.SearchResult, obj interface{}) ([]interface{}, error) {
+// parseSearchResult decodes the hits of resp into new values of the type
+// obj points to, or returns their keys for KeyOnly predicates.
+func parseSearchResult(resp *elastic.SearchResult, sp *storage.SelectionPredicate, obj interface{}) ([]interface{}, error) {
 	if resp == nil || resp.Hits == nil {
 		return make([]interface{}, 0), nil
 	}
 
 	list := make([]interface{}, len(resp.Hits.Hits))
 	for index, hit := range resp.Hits.Hits {
+		if sp != nil && sp.KeyOnly {
+			list[index] = "/" + hit.Index + "/" + hit.Type + "/" + hit.Id
+			continue
+		}
+
 		list[index] = reflect.New(reflect.TypeOf(obj).Elem()).Interface()
-		err := json.Unmarshal(*hit.Source, list[index])
+		source, _ := stripExpiry(*hit.Source)
+		err := json.Unmarshal(source, list[index])
 		if err != nil {
 			return list, err
 		}
 		//fmt.Printf("%s\n", hit.Source)
-		setResourceVersion(list[index], *hit.Version)
+		storage.SetResourceVersion(list[index], *hit.Version)
 	}
 	return list, nil
 }
 
 func getQueryByKeyword(keyword interface{}) (query elastic.Query, err error) {
-	switch v := keyword.(type) {
-	case string:
-		if v != "" {
-			query = elastic.NewQueryStringQuery(keyword.(string))
-		}
-	case map[string]interface{}:
-		querys := make([]elastic.Query, 0, len(v))
-		for field, value := range v {
-			switch v2 := value.(type) {
-			case string, int, int64, float64:
-				querys = append(querys, elastic.NewTermQuery(field, v2))
-			case []interface{}:
-				querys = append(querys, elastic.NewTermsQuery(field, v2...))
-			case map[string]interface{}:
-				querys = append(querys, NewInterfaceQuery(v2))
-			}
-		}
-		query = elastic.NewBoolQuery().Must(querys...)
-	default:
-		typ_str := reflect.TypeOf(keyword).Kind().String()
-		err = storage.NewBadRequestError("unknown keyword argument: " + typ_str)
-	}
-
-	return
-}
-
-func setResourceVersion(out interface{}, v int64) {
-	switch reflect.TypeOf(out).Kind() {
-	case reflect.Ptr:
-		v_typ := reflect.TypeOf(out).Elem()
-		if v_typ.Kind() == reflect.Map {
-			reflect.ValueOf(out).Elem().SetMapIndex(reflect.ValueOf("_version"), reflect.ValueOf(v))
-		} else if v_typ.Kind() == reflect.Struct {
-			_, ok := reflect.TypeOf(out).Elem().FieldByName("ResourceVersion")
-			if ok {
-				version_v := reflect.ValueOf(out).Elem().FieldByName("ResourceVersion")
-				if version_v.Kind() == reflect.Int64 || version_v.Kind() == reflect.Int {
-					version_v.SetInt(v)
-				} else if version_v.Kind() == reflect.String {
-					version_v.SetString(fmt.Sprintf("%d", v))
-				}
-			} else {
-				//fmt.Printf("not found ResourceVersion\n")
-			}
-		} else {
-			//fmt.Printf("unknown kind: %s\n", v_typ.Kind())
-		}
-	case reflect.Map:
-		reflect.ValueOf(out).SetMapIndex(reflect.ValueOf("_version"), reflect.ValueOf(v))
-	default:
-
+	q, err := storage.ParseKeyword(keyword)
+	if err != nil || q == nil {
+		return nil, err
 	}
-
+	return toElasticQuery(q)
 }
 
 type InterfaceQuery struct {
@@ -3,13 +3,19 @@ package elasticsearch
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/olivere/elastic.v5"
 
 	"github.com/bingbaba/storage"
+	"github.com/bingbaba/storage/storagetest"
 )
 
 func TestStore(t *testing.T) {
@@ -28,7 +34,13 @@ func TestStore(t *testing.T) {
 }
 
 func testCreate(store storage.Interface, t *testing.T) {
-	err := store.Create(context.Background(), "/myindex/mytype/myid", newObj(), 0)
+	// Create does not overwrite, so drop the document of an earlier run
+	err := store.Delete(context.Background(), "/myindex/mytype/myid", nil)
+	if err != nil && !storage.IsNotFound(err) {
+		t.Fatal(err)
+	}
+
+	err = store.Create(context.Background(), "/myindex/mytype/myid", newObj(), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -132,3 +144,288 @@ func newObj() interface{} {
 
 	return obj_map
 }
+
+func TestConformance(t *testing.T) {
+	if os.Getenv("ES_URLS") == "" {
+		t.Skip("ES_URLS is not set")
+	}
+
+	storagetest.RunConformance(t, func() storage.Interface {
+		return newTestStore(t)
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	doc, err := withExpiry("/myindex/mytype/myid", newObj(), 60, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, expired := stripExpiry(body)
+	if expired {
+		t.Fatal("document expired before its ttl")
+	}
+	if string(source) != `{"code":"myid"}` {
+		t.Fatalf("expect expiry field stripped, but get %s", source)
+	}
+
+	_, expired = stripExpiry([]byte(`{"code":"myid","_expire_at":1}`))
+	if !expired {
+		t.Fatal("expect document to be expired")
+	}
+}
+
+func TestConvertError(t *testing.T) {
+	key := "/myindex/mytype/myid"
+
+	err := convertError(&elastic.Error{Status: http.StatusConflict}, key, 3)
+	if !storage.IsConflict(err) {
+		t.Fatalf("expect ResourceVersionConflicts error, but get %v", err)
+	}
+	var esErr *elastic.Error
+	if !errors.As(err, &esErr) || esErr.Status != http.StatusConflict {
+		t.Fatal("expect the elastic error to stay reachable")
+	}
+
+	if err := convertError(&elastic.Error{Status: http.StatusNotFound}, key, 0); !storage.IsNotFound(err) {
+		t.Fatalf("expect KeyNotFound error, but get %v", err)
+	}
+	if err := convertError(&elastic.Error{Status: http.StatusBadRequest}, key, 0); !storage.IsBadRequest(err) {
+		t.Fatalf("expect BadRequest error, but get %v", err)
+	}
+	if err := convertError(elastic.ErrNoClient, key, 0); !storage.IsUnreachable(err) {
+		t.Fatalf("expect ServerUnreachable error, but get %v", err)
+	}
+	if err := convertError(&elastic.Error{Status: http.StatusInternalServerError}, key, 0); !storage.IsInternalError(err) {
+		t.Fatalf("expect InternalError, but get %v", err)
+	}
+}
+
+func TestSorters(t *testing.T) {
+	list, err := sorters([]storage.SortField{
+		{Field: "count", Descending: true, Missing: storage.SortMissingFirst},
+		{Field: "code"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := make([]interface{}, len(list))
+	for i, sorter := range list {
+		if source[i], err = sorter.Source(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, _ := json.Marshal(source)
+	want := `[{"count":{"missing":"_first","order":"desc"}},{"code":{"order":"asc"}}]`
+	if string(body) != want {
+		t.Fatalf("expect %s, but get %s", want, body)
+	}
+
+	if _, err := sorters([]storage.SortField{{}}); !storage.IsBadRequest(err) {
+		t.Fatalf("expect BadRequest error, but get %v", err)
+	}
+}
+
+func TestFetchSource(t *testing.T) {
+	if fetchSource(storage.Projection{}) != nil {
+		t.Fatal("expect whole documents without a projection")
+	}
+
+	ctx := context.WithValue(context.Background(), "excludes", []string{"secret"})
+	p := listProjection(ctx, &storage.SelectionPredicate{Includes: []string{"code"}})
+	source, err := fetchSource(p).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(source)
+	want := `{"excludes":["secret"],"includes":["code","_expire_at"]}`
+	if string(body) != want {
+		t.Fatalf("expect %s, but get %s", want, body)
+	}
+}
+
+func TestToElasticQuery(t *testing.T) {
+	query, err := getQueryByKeyword(storage.Or(
+		storage.Eq("group", "even"),
+		storage.Not(storage.Range("count").GreaterOrEqual(3)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := query.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(source)
+	want := `{"bool":{"minimum_should_match":"1","should":[{"term":{"group":"even"}},{"bool":{"must_not":{"range":{"count":{"from":3,"include_lower":true,"include_upper":true,"to":null}}}}}]}}`
+	if string(body) != want {
+		t.Fatalf("expect %s, but get %s", want, body)
+	}
+}
+
+func TestRangeKeyword(t *testing.T) {
+	day := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		keyword interface{}
+		want    string
+	}{
+		{
+			map[string]interface{}{"createdAt": map[string]interface{}{"gte": "now-7d/d", "lt": "now/d", "time_zone": "+08:00"}},
+			`{"bool":{"must":{"range":{"createdAt":{"from":"now-7d/d","include_lower":true,"include_upper":false,"time_zone":"+08:00","to":"now/d"}}}}}`,
+		},
+		{
+			storage.TimeWindow("createdAt", day, day.AddDate(0, 0, 1)),
+			`{"range":{"createdAt":{"from":"2024-03-14T00:00:00.000Z","include_lower":true,"include_upper":false,"to":"2024-03-15T00:00:00.000Z"}}}`,
+		},
+	} {
+		query, err := getQueryByKeyword(tt.keyword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source, err := query.Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := json.Marshal(source)
+		if string(body) != tt.want {
+			t.Fatalf("expect %s, but get %s", tt.want, body)
+		}
+	}
+}
+
+func TestToElasticAgg(t *testing.T) {
+	agg := storage.DateHistogramAgg("at", "day").Sub("total", storage.SumAgg("size"))
+	agg.TimeZone = "+08:00"
+
+	source, err := toElasticAgg(agg).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(source)
+	want := `{"aggregations":{"total":{"sum":{"field":"size"}}},"date_histogram":{"field":"at","interval":"day","time_zone":"+08:00"}}`
+	if string(body) != want {
+		t.Fatalf("expect %s, but get %s", want, body)
+	}
+}
+
+func TestBulkFlushInterval(t *testing.T) {
+	bulks := make(chan int, 10)
+	s := newHandlerStore(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		n := strings.Count(string(body), "\n") / 2
+		items := make([]string, n)
+		for i := range items {
+			items[i] = `{"index":{"_index":"i","_type":"t","_id":"x","status":201}}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":false,"items":[` + strings.Join(items, ",") + `]}`))
+		bulks <- n
+	})
+
+	c := make(chan storage.ChannelObj)
+	done := make(chan *storage.BulkSummary)
+	go func() {
+		summary, err := s.BulkCreateWithOptions(context.Background(), "/i/t", c, 0, storage.BulkOptions{})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- summary
+	}()
+
+	c <- storage.ChannelObj{Id: "1", Data: map[string]int{"n": 1}}
+	select {
+	case n := <-bulks:
+		if n != 1 {
+			t.Fatalf("expect a bulk request with 1 item, but get %d", n)
+		}
+	case <-time.After(3 * bulkFlushInterval):
+		t.Fatal("expect a partial batch to be sent while the channel stays open")
+	}
+
+	close(c)
+	if summary := <-done; summary == nil || summary.Succeeded != 1 {
+		t.Fatalf("expect 1 item written, but get %+v", summary)
+	}
+}
+
+// newHandlerStore returns a store talking to an httptest server serving
+// handler, which is shut down with the test.
+func newHandlerStore(t *testing.T, handler http.HandlerFunc) *store {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	client, err := elastic.NewClient(elastic.SetURL(srv.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &store{client: client}
+}
+
+func TestListKeyOnly(t *testing.T) {
+	var body string
+	s := newHandlerStore(t, func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		body = string(bs)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"hits":{"total":2,"hits":[
+			{"_index":"i","_type":"t","_id":"a","_version":1},
+			{"_index":"i","_type":"t","_id":"b","_version":3}]}}`))
+	})
+
+	list, err := s.List(context.Background(), "/i/t", &storage.SelectionPredicate{KeyOnly: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `"_source":false`) {
+		t.Fatalf("expect the search to fetch no _source, but get %s", body)
+	}
+	if len(list) != 2 || list[0] != "/i/t/a" || list[1] != "/i/t/b" {
+		t.Fatalf("expect the keys of the hits, but get %v", list)
+	}
+}
+
+func TestCreateExists(t *testing.T) {
+	var source string
+	var indexed, conflict bool
+	s := newHandlerStore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"_index":"i","_type":"t","_id":"a","_version":2,"found":true,"_source":` + source + `}`))
+		case r.URL.Query().Get("version") != "2" || conflict:
+			// create requests, and writes racing with another writer
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":{"type":"version_conflict_engine_exception","reason":"conflict"},"status":409}`))
+		default:
+			indexed = true
+			w.Write([]byte(`{"_index":"i","_type":"t","_id":"a","_version":3,"created":false}`))
+		}
+	})
+	ctx := context.Background()
+
+	source = `{"n":1}`
+	err := s.Create(ctx, "/i/t/a", map[string]int{"n": 2}, 0)
+	if !storage.IsNodeExist(err) || indexed {
+		t.Fatalf("expect KeyExists for a live document, but get %v", err)
+	}
+
+	source = `{"n":1,"` + ExpireAtField + `":1}`
+	conflict = true
+	if err := s.Create(ctx, "/i/t/a", map[string]int{"n": 2}, 0); !storage.IsNodeExist(err) || indexed {
+		t.Fatalf("expect KeyExists when the expired document changes concurrently, but get %v", err)
+	}
+
+	conflict = false
+	if err := s.Create(ctx, "/i/t/a", map[string]int{"n": 2}, 0); err != nil || !indexed {
+		t.Fatalf("expect the expired document to be replaced, but get %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// ExpireAtField is the document field holding the expiry time of documents
+// written with a ttl, in milliseconds since the epoch. It is stripped from
+// every document read back through the store.
+var ExpireAtField = "_expire_at"
+
+// withExpiry returns obj with ExpireAtField set ttl seconds from now. With a
+// zero ttl obj is returned unchanged, unless clear is set, in which case the
+// field is reset so that a partial update drops an earlier expiry.
+func withExpiry(key string, obj interface{}, ttl uint64, clear bool) (interface{}, error) {
+	if ttl == 0 && !clear {
+		return obj, nil
+	}
+
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, storage.NewInvalidObjError(key, err.Error())
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil || doc == nil {
+		return nil, storage.NewInvalidObjError(key, "a document with a ttl must be a JSON object")
+	}
+
+	if ttl > 0 {
+		doc[ExpireAtField] = expireAt(ttl)
+	} else {
+		doc[ExpireAtField] = nil
+	}
+	return doc, nil
+}
+
+func expireAt(ttl uint64) int64 {
+	return toMillis(time.Now().Add(time.Duration(ttl) * time.Second))
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// stripExpiry removes ExpireAtField from the document source and reports
+// whether the document has expired.
+func stripExpiry(source []byte) ([]byte, bool) {
+	if !bytes.Contains(source, []byte(ExpireAtField)) {
+		return source, false
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(source, &doc); err != nil {
+		return source, false
+	}
+	raw, ok := doc[ExpireAtField]
+	if !ok {
+		return source, false
+	}
+	delete(doc, ExpireAtField)
+
+	var expired bool
+	var at int64
+	if err := json.Unmarshal(raw, &at); err == nil && at > 0 {
+		expired = at <= toMillis(time.Now())
+	}
+
+	stripped, err := json.Marshal(doc)
+	if err != nil {
+		return source, expired
+	}
+	return stripped, expired
+}
+
+// notExpired wraps query so that it skips expired documents.
+func notExpired(query elastic.Query) elastic.Query {
+	bq := elastic.NewBoolQuery().MustNot(expiredQuery())
+	if query != nil {
+		bq = bq.Must(query)
+	}
+	return bq
+}
+
+func expiredQuery() elastic.Query {
+	return elastic.NewRangeQuery(ExpireAtField).Lte(toMillis(time.Now()))
+}
+
+// ReapExpired deletes the expired documents below key, which must match the
+// "/index[/type]" pattern and may use index wildcards.
+func (s *store) ReapExpired(ctx context.Context, key string) (int64, error) {
+	key_array := strings.SplitN(key, "/", 4)
+	if len(key_array) < 2 || key_array[1] == "" {
+		return 0, storage.NewBadRequestError("the key must match \"/index\" pattern")
+	}
+
+	us := elastic.NewDeleteByQueryService(s.client).
+		Index(key_array[1]).
+		Query(expiredQuery()).
+		ProceedOnVersionConflict()
+	if len(key_array) >= 3 && key_array[2] != "" {
+		us = us.Type(key_array[2])
+	}
+
+	resp, err := us.Do(ctx)
+	if err != nil {
+		return 0, convertError(err, key, 0)
+	}
+	return resp.Deleted, nil
+}
+
+// StartReaper runs ReapExpired on key every interval until ctx is done. The
+// errors of failed passes are passed to onError, which may be nil.
+func (s *store) StartReaper(ctx context.Context, key string, interval time.Duration, onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.ReapExpired(ctx, key); err != nil && onError != nil && ctx.Err() == nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
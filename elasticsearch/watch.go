@@ -0,0 +1,79 @@
+package elasticsearch
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/bingbaba/storage"
+)
+
+// Watch polls the documents matching sp every WatchInterval and reports the
+// differences between consecutive polls based on the document versions. Any
+// non-zero resourceVersion starts watching from the current state, since
+// elasticsearch versions are per document.
+func (s *store) Watch(ctx context.Context, key string, sp *storage.SelectionPredicate, resourceVersion int64) (<-chan storage.WatchEvent, error) {
+	key_array := strings.SplitN(key, "/", 4)
+	var idx, typ string
+	if len(key_array) >= 2 {
+		idx = key_array[1]
+	}
+	if len(key_array) >= 3 {
+		typ = key_array[2]
+	}
+
+	var keyword interface{}
+	if sp != nil {
+		keyword = sp.Keyword
+	}
+
+	poll := func(ctx context.Context) (map[string]storage.WatchState, error) {
+		states := make(map[string]storage.WatchState)
+		scroll := &storage.SelectionPredicate{Keyword: keyword, ScrollKeepAlive: "1m", Limit: 1000}
+
+		// every poll opens a scroll, which must not linger until its keep
+		// alive elapses
+		var scrollId string
+		defer func() {
+			if scrollId != "" {
+				s.clearScroll(scrollId)
+			}
+		}()
+
+		for {
+			resp, err := s.listByScroll(ctx, idx, typ, scroll)
+			if scroll.ScrollId != "" {
+				scrollId = scroll.ScrollId
+			}
+			if err != nil {
+				return nil, err
+			}
+			if scroll.EOF || resp == nil || resp.Hits == nil {
+				return states, nil
+			}
+
+			for _, hit := range resp.Hits.Hits {
+				if hit.Version == nil || hit.Source == nil {
+					continue
+				}
+				source, _ := stripExpiry(*hit.Source)
+				states["/"+hit.Index+"/"+hit.Type+"/"+hit.Id] = storage.WatchState{
+					ResourceVersion: *hit.Version,
+					Object:          source,
+				}
+			}
+		}
+	}
+
+	return storage.PollWatch(ctx, WatchInterval, resourceVersion, poll, nil)
+}
+
+// clearScroll releases the scroll context scrollId. It runs detached from the
+// poll context, which may already be done.
+func (s *store) clearScroll(scrollId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	elastic.NewClearScrollService(s.client).ScrollId(scrollId).Do(ctx)
+}
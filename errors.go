@@ -75,6 +75,9 @@ type StorageError struct {
 	Key                string
 	ResourceVersion    int64
 	AdditionalErrorMsg string
+
+	// Err is the backend error this error was classified from, if any.
+	Err error
 }
 
 func (e *StorageError) Error() string {
@@ -82,6 +85,20 @@ func (e *StorageError) Error() string {
 		errCodeToMessage[e.Code], e.Code, e.Key, e.ResourceVersion, e.AdditionalErrorMsg)
 }
 
+// Unwrap returns the backend error, so errors.As can reach it.
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// WithCause records err as the backend cause of e and returns e.
+func (e *StorageError) WithCause(err error) *StorageError {
+	e.Err = err
+	if e.AdditionalErrorMsg == "" && err != nil {
+		e.AdditionalErrorMsg = err.Error()
+	}
+	return e
+}
+
 func GetErrMesage(err error) string {
 	if e, ok := err.(*StorageError); ok {
 		return errCodeToMessage[e.Code]
@@ -130,16 +147,53 @@ func isErrCode(err error, code int) bool {
 	return false
 }
 
+// ListFailure is a listed object that could not be loaded.
+type ListFailure struct {
+	Key string
+	Err error
+}
+
+// ListError is returned along with a partial result by List when some of the
+// listed objects could not be fetched or decoded, so that callers can tell a
+// failed object from an empty listing. The failed objects are nil in the
+// result, or left out when SelectionPredicate.SkipFailed is set.
+type ListError struct {
+	Listed int
+	Failed []ListFailure
+}
+
+func (e *ListError) Error() string {
+	if len(e.Failed) == 0 {
+		return fmt.Sprintf("ListError: 0 of %d objects failed", e.Listed)
+	}
+	return fmt.Sprintf("ListError: %d of %d objects failed, first: %s: %v",
+		len(e.Failed), e.Listed, e.Failed[0].Key, e.Failed[0].Err)
+}
+
+// IsListError returns true if and only if err is a ListError.
+func IsListError(err error) bool {
+	_, ok := err.(*ListError)
+	return ok
+}
+
 // InternalError is generated when an error occurs in the storage package, i.e.,
 // not from the underlying storage backend (e.g., etcd).
 type InternalError struct {
 	Reason string
+
+	// Err is the backend error, if any.
+	Err error
 }
 
 func (e InternalError) Error() string {
 	return e.Reason
 }
 
+// Unwrap returns the backend error, so errors.As can reach it.
+func (e InternalError) Unwrap() error {
+	return e.Err
+}
+
 // IsInternalError returns true if and only if err is an InternalError.
 func IsInternalError(err error) bool {
 	_, ok := err.(InternalError)
@@ -155,11 +209,11 @@ func IsTimeoutError(err error) bool {
 }
 
 func NewInternalError(reason string) InternalError {
-	return InternalError{reason}
+	return InternalError{Reason: reason}
 }
 
 func NewInternalErrorf(format string, a ...interface{}) InternalError {
-	return InternalError{fmt.Sprintf(format, a...)}
+	return InternalError{Reason: fmt.Sprintf(format, a...)}
 }
 
 func ParseToHttpError(err error) (code int, msg, detail string) {
@@ -1,8 +1,15 @@
 module github.com/bingbaba/storage
 
-go 1.13
+go 1.18
 
 require (
 	github.com/tencentyun/cos-go-sdk-v5 v0.7.7
 	gopkg.in/olivere/elastic.v5 v5.0.84
 )
+
+require (
+	github.com/google/go-querystring v1.0.0 // indirect
+	github.com/mailru/easyjson v0.0.0-20180730094502-03f2033d19d5 // indirect
+	github.com/mozillazg/go-httpheader v0.2.1 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+)
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+const (
+	guaranteedUpdateAttempts   = 10
+	guaranteedUpdateBackoff    = 10 * time.Millisecond
+	guaranteedUpdateMaxBackoff = time.Second
+)
+
+// GuaranteedUpdate reads the object stored under key into out, passes it to
+// tryUpdate and writes the returned object back with the resource version
+// that was read. When the write conflicts with another writer the whole
+// cycle is retried with exponential backoff, so tryUpdate must be free of
+// side effects. On success out holds the stored object.
+//
+// The object keeps its remaining ttl on backends implementing TTLReader and
+// is written without a ttl on the others. Backends that report no resource
+// version write unconditionally.
+func GuaranteedUpdate(ctx context.Context, s Interface, key string, out interface{},
+	tryUpdate func(current interface{}) (interface{}, error)) error {
+
+	if out == nil || reflect.TypeOf(out).Kind() != reflect.Ptr || reflect.ValueOf(out).IsNil() {
+		return NewBadRequestError("GuaranteedUpdate needs a non-nil pointer")
+	}
+
+	backoff := guaranteedUpdateBackoff
+	var err error
+	for attempt := 0; attempt < guaranteedUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(sleep):
+			}
+			if backoff *= 2; backoff > guaranteedUpdateMaxBackoff {
+				backoff = guaranteedUpdateMaxBackoff
+			}
+		}
+
+		reset(out)
+		if err = s.Get(ctx, key, out); err != nil {
+			return err
+		}
+
+		var obj interface{}
+		obj, err = tryUpdate(out)
+		if err != nil {
+			return err
+		}
+
+		// the ttl is read after the object, so a rewrite in between fails
+		// the update with a conflict
+		var ttl uint64
+		if r, ok := s.(TTLReader); ok {
+			if ttl, err = r.TTL(ctx, key); err != nil {
+				return err
+			}
+		}
+
+		err = s.Update(ctx, key, GetResourceVersion(out), obj, ttl)
+		if IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		reset(out)
+		return s.Get(ctx, key, out)
+	}
+
+	return err
+}
+
+// reset sets the value out points to back to its zero value, so that a map
+// does not keep keys from an earlier read.
+func reset(out interface{}) {
+	v := reflect.ValueOf(out).Elem()
+	v.Set(reflect.Zero(v.Type()))
+}
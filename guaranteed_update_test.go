@@ -0,0 +1,58 @@
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bingbaba/storage"
+	"github.com/bingbaba/storage/memory"
+)
+
+type counter struct {
+	Count           int   `json:"count"`
+	ResourceVersion int64 `json:"-"`
+}
+
+func TestGuaranteedUpdate(t *testing.T) {
+	s := memory.NewStore()
+	key := "/counters/a"
+	if err := s.Create(context.Background(), key, &counter{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var out counter
+			err := storage.GuaranteedUpdate(context.Background(), s, key, &out,
+				func(current interface{}) (interface{}, error) {
+					c := current.(*counter)
+					return &counter{Count: c.Count + 1}, nil
+				})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var out counter
+	if err := s.Get(context.Background(), key, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Count != 5 {
+		t.Fatalf("expect count 5, but get %d", out.Count)
+	}
+
+	err := storage.GuaranteedUpdate(context.Background(), s, "/counters/missing", &out,
+		func(current interface{}) (interface{}, error) {
+			return current, nil
+		})
+	if !storage.IsNotFound(err) {
+		t.Fatalf("expect KeyNotFound error, but get %v", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 )
 
+// Interface is implemented by every storage backend. Keys are slash separated
+// paths, ttl is given in seconds and zero means the object never expires.
 type Interface interface {
 	Get(ctx context.Context, key string, out interface{}) error
 	Create(ctx context.Context, key string, obj interface{}, ttl uint64) error
@@ -19,3 +21,18 @@ type ChannelObj struct {
 	Data interface{}
 	Id   string
 }
+
+// DirListing is a single level of a hierarchical listing.
+type DirListing struct {
+	// Items are the objects directly below the listed key.
+	Items []interface{}
+
+	// Prefixes are the common prefixes of the deeper keys, each ending with
+	// the delimiter, like the subdirectories of a directory.
+	Prefixes []string
+}
+
+// DirLister is implemented by backends with directory-style listings.
+type DirLister interface {
+	ListDir(ctx context.Context, key string, sp *SelectionPredicate, obj interface{}) (*DirListing, error)
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/bingbaba/storage"
+)
+
+// ListWithAggregations computes aggs in process over every object List
+// would select. The page and the aggregations come from the same snapshot
+// of the store. Scrolls and cursors are not supported.
+func (s *store) ListWithAggregations(ctx context.Context, key string, sp *storage.SelectionPredicate, obj interface{},
+	aggs map[string]*storage.Aggregation) ([]interface{}, map[string]*storage.AggregationResult, error) {
+
+	var keyword interface{}
+	if sp != nil {
+		if sp.SearchAfter || sp.ScrollKeepAlive != "" || sp.ScrollId != "" {
+			return nil, nil, storage.NewBadRequestError("aggregations cannot be combined with a scroll or a cursor")
+		}
+		if sp.Delimiter != "" {
+			return nil, nil, storage.NewBadRequestError("the memory store does not support directory listings")
+		}
+		keyword = sp.Keyword
+	}
+	if obj != nil && reflect.TypeOf(obj).Kind() != reflect.Ptr {
+		return nil, nil, storage.NewBadRequestError("non-pointer " + reflect.TypeOf(obj).String())
+	}
+	filter, err := newFilter(keyword)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	entries := s.match(key, filter, time.Now())
+	s.mu.RUnlock()
+
+	docs := make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		if doc, ok := parseDoc(e.item.data); ok {
+			docs = append(docs, doc)
+		}
+	}
+	results, err := storage.AggregateDocs(docs, aggs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if obj == nil {
+		return nil, results, nil
+	}
+	entries, err = searchPage(entries, sp)
+	if err != nil {
+		return nil, nil, err
+	}
+	list, err := decodeEntries(entries, sp, obj)
+	return list, results, err
+}
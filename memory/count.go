@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/bingbaba/storage"
+)
+
+func (s *store) Exists(ctx context.Context, key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.lookup(key, time.Now())
+	return ok, nil
+}
+
+func (s *store) Count(ctx context.Context, key string, sp *storage.SelectionPredicate) (int64, error) {
+	var keyword interface{}
+	if sp != nil {
+		keyword = sp.Keyword
+	}
+	filter, err := newFilter(keyword)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.match(key, filter, time.Now()))), nil
+}
+
+func (s *store) TTL(ctx context.Context, key string) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	it, ok := s.lookup(key, time.Now())
+	if !ok {
+		return 0, storage.NewKeyNotFoundError(key, 0)
+	}
+	return storage.RemainingTTL(it.expireAt), nil
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"encoding/json"
+
+	"github.com/bingbaba/storage"
+)
+
+// filter reports whether the JSON document data matches a keyword.
+type filter func(data []byte) bool
+
+// newFilter evaluates a SelectionPredicate keyword in process, see
+// storage.ParseKeyword. Raw elasticsearch query clauses are rejected.
+func newFilter(keyword interface{}) (filter, error) {
+	q, err := storage.ParseKeyword(keyword)
+	if err != nil || q == nil {
+		return nil, err
+	}
+
+	m, err := storage.NewMatcher(q)
+	if err != nil {
+		return nil, err
+	}
+	return func(data []byte) bool {
+		doc, ok := parseDoc(data)
+		return ok && m(doc)
+	}, nil
+}
+
+func parseDoc(data []byte) (map[string]interface{}, bool) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, false
+	}
+	return doc, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,20 @@
+package memory
+
+import (
+	"context"
+)
+
+func (s *store) GetMany(ctx context.Context, keys []string, newObj func() interface{}) (map[string]interface{}, map[string]error) {
+	objs := make(map[string]interface{}, len(keys))
+	errs := make(map[string]error)
+	for _, key := range keys {
+		obj := newObj()
+		if err := s.Get(ctx, key, obj); err != nil {
+			errs[key] = err
+			continue
+		}
+		objs[key] = obj
+	}
+
+	return objs, errs
+}
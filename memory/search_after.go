@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"io"
+	"time"
+
+	"github.com/bingbaba/storage"
+)
+
+// defaultPageSize is the page size of cursor paging without a Limit, the
+// elasticsearch default.
+const defaultPageSize = 10
+
+// listBySearchAfter lists the page of a cursor paged listing that follows the
+// sort values encoded in sp.Cursor.
+func (s *store) listBySearchAfter(key string, sp *storage.SelectionPredicate) ([]entry, error) {
+	if sp.EOF {
+		sp.Cursor = ""
+		return nil, io.EOF
+	}
+	if sp.From > 0 {
+		return nil, storage.NewBadRequestError("from must be zero when paging with a cursor")
+	}
+
+	var after []interface{}
+	if sp.Cursor != "" {
+		var err error
+		after, err = storage.DecodeCursor(sp.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if len(after) != len(sp.Sort)+1 {
+			return nil, storage.NewBadRequestError("cursor does not match the sort fields: " + sp.Cursor)
+		}
+	}
+
+	filter, err := newFilter(sp.Keyword)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	entries := s.match(key, filter, time.Now())
+	s.mu.RUnlock()
+
+	rows, err := sortRows(entries, sp.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if after != nil {
+		for start < len(rows) && compareRows(rows[start], after, sp.Sort) <= 0 {
+			start++
+		}
+	}
+
+	size := sp.Limit
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	end := start + size
+	if end >= len(entries) {
+		end = len(entries)
+		sp.EOF = true
+	}
+
+	if end > start {
+		sp.Cursor, err = storage.EncodeCursor(rows[end-1])
+		if err != nil {
+			return nil, storage.NewInternalError(err.Error())
+		}
+	}
+	return entries[start:end], nil
+}
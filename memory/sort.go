@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bingbaba/storage"
+)
+
+// sortEntries orders entries by sorts, emulating elasticsearch field sorts:
+// numbers compare numerically, other values as strings, and arrays by their
+// smallest element ascending or largest descending. Entries that compare
+// equal keep their key order.
+func sortEntries(entries []entry, sorts []storage.SortField) error {
+	if len(sorts) == 0 {
+		return nil
+	}
+	_, err := sortRows(entries, sorts)
+	return err
+}
+
+// sortRows sorts entries like sortEntries and returns the sort values of
+// every entry, followed by its key as tiebreaker.
+func sortRows(entries []entry, sorts []storage.SortField) ([][]interface{}, error) {
+	for _, sf := range sorts {
+		if sf.Field == "" {
+			return nil, storage.NewBadRequestError("sort field must not be empty")
+		}
+	}
+
+	rows := make([][]interface{}, len(entries))
+	for n, e := range entries {
+		doc, _ := parseDoc(e.item.data)
+		row := make([]interface{}, len(sorts)+1)
+		for i, sf := range sorts {
+			row[i] = sortValue(storage.LookupField(doc, sf.Field), sf.Descending)
+		}
+		row[len(sorts)] = e.key
+		rows[n] = row
+	}
+
+	sort.Stable(byRow{entries: entries, rows: rows, sorts: sorts})
+	return rows, nil
+}
+
+type byRow struct {
+	entries []entry
+	rows    [][]interface{}
+	sorts   []storage.SortField
+}
+
+func (b byRow) Len() int { return len(b.entries) }
+
+func (b byRow) Swap(i, j int) {
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+	b.rows[i], b.rows[j] = b.rows[j], b.rows[i]
+}
+
+func (b byRow) Less(i, j int) bool {
+	return compareRows(b.rows[i], b.rows[j], b.sorts) < 0
+}
+
+// compareRows compares two rows of sortRows, tiebreaker included.
+func compareRows(a, b []interface{}, sorts []storage.SortField) int {
+	for n := range a {
+		var sf storage.SortField
+		if n < len(sorts) {
+			sf = sorts[n]
+		}
+		if c := compareSortValues(a[n], b[n], sf); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// sortValue reduces an array to the element it is sorted by.
+func sortValue(value interface{}, desc bool) interface{} {
+	values, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+
+	var best interface{}
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		if best == nil {
+			best = v
+			continue
+		}
+		c := compareValues(v, best)
+		if (desc && c > 0) || (!desc && c < 0) {
+			best = v
+		}
+	}
+	return best
+}
+
+// compareSortValues compares two sort values in the order of sf.
+func compareSortValues(a, b interface{}, sf storage.SortField) int {
+	if a == nil || b == nil {
+		// Missing may hold a value that cannot be compared with ==
+		sentinel, _ := sf.Missing.(string)
+		switch {
+		case sf.Missing == nil || sentinel == storage.SortMissingLast:
+			return missingOrder(a, b)
+		case sentinel == storage.SortMissingFirst:
+			return -missingOrder(a, b)
+		}
+		if a == nil {
+			a = sf.Missing
+		}
+		if b == nil {
+			b = sf.Missing
+		}
+	}
+
+	c := compareValues(a, b)
+	if sf.Descending {
+		return -c
+	}
+	return c
+}
+
+// missingOrder places nil values after the others.
+func missingOrder(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return 0
+}
+
+func compareValues(a, b interface{}) int {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprintf("%v", a), fmt.Sprintf("%v", b))
+}
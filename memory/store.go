@@ -0,0 +1,490 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingbaba/storage"
+)
+
+const (
+	// maxResultWindow mirrors the elasticsearch index.max_result_window default.
+	maxResultWindow = 10000
+
+	defaultScrollKeepAlive = time.Minute
+)
+
+type item struct {
+	data     []byte
+	version  int64
+	expireAt time.Time
+}
+
+func (i *item) expired(now time.Time) bool {
+	return !i.expireAt.IsZero() && !now.Before(i.expireAt)
+}
+
+type entry struct {
+	key string
+	*item
+}
+
+type scrollContext struct {
+	entries  []entry
+	offset   int
+	expireAt time.Time
+}
+
+// store is an in-process implementation of storage.Interface. Documents are
+// kept as JSON and every write bumps a store-wide resource version, so it
+// behaves like the elasticsearch store without a running cluster.
+type store struct {
+	mu      sync.RWMutex
+	items   map[string]*item
+	version int64
+
+	scrolls   map[string]*scrollContext
+	scrollSeq int64
+
+	watchers   map[int64]*watcher
+	watcherSeq int64
+}
+
+func NewStore() *store {
+	return &store{
+		items:    make(map[string]*item),
+		scrolls:  make(map[string]*scrollContext),
+		watchers: make(map[int64]*watcher),
+	}
+}
+
+func (s *store) Get(ctx context.Context, key string, out interface{}) error {
+	s.mu.RLock()
+	it, ok := s.lookup(key, time.Now())
+	s.mu.RUnlock()
+	if !ok {
+		return storage.NewKeyNotFoundError(key, 0)
+	}
+
+	return decode(key, it, out, storage.ProjectionFrom(ctx))
+}
+
+func (s *store) Create(ctx context.Context, key string, obj interface{}, ttl uint64) error {
+	data, err := encode(key, obj)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cur, ok := s.lookup(key, time.Now()); ok {
+		return storage.NewKeyExistsError(key, cur.version)
+	}
+	s.put(key, data, ttl)
+
+	return nil
+}
+
+func (s *store) BulkCreate(ctx context.Context, key string, c chan storage.ChannelObj, ttl uint64) error {
+	summary, err := s.BulkCreateWithOptions(ctx, key, c, ttl, storage.BulkOptions{})
+	if err != nil {
+		return err
+	}
+	return summary.Err()
+}
+
+// BulkCreateWithOptions indexes every object under key/<id>. Like the
+// elasticsearch bulk index request it overwrites documents that already exist.
+func (s *store) BulkCreateWithOptions(ctx context.Context, key string, c chan storage.ChannelObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return storage.RunBulk(ctx, c, 1, opts, func(ctx context.Context, obj storage.ChannelObj) (string, error) {
+		data, err := encode(key+"/"+obj.Id, obj.Data)
+		if err != nil {
+			return obj.Id, err
+		}
+
+		s.mu.Lock()
+		s.put(key+"/"+obj.Id, data, ttl)
+		s.mu.Unlock()
+		return obj.Id, nil
+	})
+}
+
+func (s *store) BulkUpdate(ctx context.Context, key string, c chan storage.BulkUpdateObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return storage.RunBulk(ctx, c, 1, opts, func(ctx context.Context, obj storage.BulkUpdateObj) (string, error) {
+		return obj.Id, s.Update(ctx, key+"/"+obj.Id, obj.ResourceVersion, obj.Data, ttl)
+	})
+}
+
+func (s *store) BulkUpsert(ctx context.Context, key string, c chan storage.BulkUpdateObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return storage.RunBulk(ctx, c, 1, opts, func(ctx context.Context, obj storage.BulkUpdateObj) (string, error) {
+		return obj.Id, s.Upsert(ctx, key+"/"+obj.Id, obj.ResourceVersion, obj.Data, obj.Insert, ttl)
+	})
+}
+
+func (s *store) BulkDelete(ctx context.Context, key string, ids chan string, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return storage.RunBulk(ctx, ids, 1, opts, func(ctx context.Context, id string) (string, error) {
+		return id, s.Delete(ctx, key+"/"+id, nil)
+	})
+}
+
+func (s *store) Delete(ctx context.Context, key string, out interface{}) error {
+	s.mu.Lock()
+	it, ok := s.lookup(key, time.Now())
+	if ok {
+		s.remove(key, it)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return storage.NewKeyNotFoundError(key, 0)
+	}
+	return decode(key, it, out, storage.Projection{})
+}
+
+func (s *store) DeleteByQuery(ctx context.Context, key string, keyword interface{}) (deleted, conflict int64, err error) {
+	filter, err := newFilter(keyword)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.match(key, filter, time.Now()) {
+		s.remove(e.key, e.item)
+		deleted++
+	}
+
+	return deleted, 0, nil
+}
+
+func (s *store) List(ctx context.Context, key string, sp *storage.SelectionPredicate, obj interface{}) ([]interface{}, error) {
+	if sp == nil || !sp.KeyOnly {
+		if obj == nil {
+			return nil, storage.NewBadRequestError("non-pointer")
+		}
+		if reflect.TypeOf(obj).Kind() != reflect.Ptr {
+			return nil, storage.NewBadRequestError("non-pointer " + reflect.TypeOf(obj).String())
+		}
+	}
+	if sp != nil && sp.Delimiter != "" {
+		return nil, storage.NewBadRequestError("the memory store does not support directory listings")
+	}
+
+	var entries []entry
+	var err error
+	if sp != nil && sp.SearchAfter {
+		if sp.ScrollKeepAlive != "" || sp.ScrollId != "" {
+			return nil, storage.NewBadRequestError("cursor paging cannot be combined with a scroll")
+		}
+		entries, err = s.listBySearchAfter(key, sp)
+	} else if sp != nil && (sp.ScrollKeepAlive != "" || sp.ScrollId != "") {
+		entries, err = s.listByScroll(key, sp)
+	} else {
+		entries, err = s.listBySearch(key, sp)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeEntries(entries, sp, obj)
+}
+
+// decodeEntries decodes entries into new values of the type obj points to,
+// or returns their keys for KeyOnly predicates.
+func decodeEntries(entries []entry, sp *storage.SelectionPredicate, obj interface{}) ([]interface{}, error) {
+	list := make([]interface{}, len(entries))
+	for index, e := range entries {
+		if sp != nil && sp.KeyOnly {
+			list[index] = e.key
+			continue
+		}
+
+		list[index] = reflect.New(reflect.TypeOf(obj).Elem()).Interface()
+		if err := decode(e.key, e.item, list[index], sp.Projection()); err != nil {
+			return list, err
+		}
+	}
+
+	return list, nil
+}
+
+func (s *store) listBySearch(key string, sp *storage.SelectionPredicate) ([]entry, error) {
+	var keyword interface{}
+	if sp != nil {
+		keyword = sp.Keyword
+	}
+	filter, err := newFilter(keyword)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	entries := s.match(key, filter, time.Now())
+	s.mu.RUnlock()
+
+	return searchPage(entries, sp)
+}
+
+// searchPage sorts the matching entries by sp and returns the page selected
+// by From and Limit.
+func searchPage(entries []entry, sp *storage.SelectionPredicate) ([]entry, error) {
+	var from, size int
+	var sorts []storage.SortField
+	if sp != nil {
+		from, size, sorts = sp.From, sp.Limit, sp.Sort
+	}
+	if from > 0 && from+size > maxResultWindow {
+		return nil, storage.NewBadRequestError(fmt.Sprintf("from+size parameter must be less than %d", maxResultWindow))
+	}
+
+	if err := sortEntries(entries, sorts); err != nil {
+		return nil, err
+	}
+	return page(entries, from, size), nil
+}
+
+func (s *store) listByScroll(key string, sp *storage.SelectionPredicate) ([]entry, error) {
+	if sp.EOF {
+		sp.ScrollId = ""
+		return nil, io.EOF
+	}
+
+	keepAlive := defaultScrollKeepAlive
+	if sp.ScrollKeepAlive != "" {
+		d, err := time.ParseDuration(sp.ScrollKeepAlive)
+		if err != nil {
+			return nil, storage.NewBadRequestError("invalid scroll keep alive: " + sp.ScrollKeepAlive)
+		}
+		keepAlive = d
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.expireScrolls(now)
+
+	scrollId := sp.ScrollId
+	sc, ok := s.scrolls[scrollId]
+	if scrollId == "" {
+		filter, err := newFilter(sp.Keyword)
+		if err != nil {
+			return nil, err
+		}
+
+		entries := s.match(key, filter, now)
+		if err := sortEntries(entries, sp.Sort); err != nil {
+			return nil, err
+		}
+
+		s.scrollSeq++
+		scrollId = fmt.Sprintf("scroll-%d", s.scrollSeq)
+		sc = &scrollContext{entries: entries}
+		s.scrolls[scrollId] = sc
+	} else if !ok {
+		return nil, storage.NewBadRequestError("scroll id not found or expired: " + scrollId)
+	}
+	sc.expireAt = now.Add(keepAlive)
+
+	entries := page(sc.entries, sc.offset, sp.Limit)
+	sc.offset += len(entries)
+	if len(entries) == 0 {
+		delete(s.scrolls, scrollId)
+		sp.EOF = true
+		sp.ScrollId = ""
+		return entries, nil
+	}
+
+	sp.ScrollId = scrollId
+	return entries, nil
+}
+
+func (s *store) Update(ctx context.Context, key string, resourceVersion int64, obj interface{}, ttl uint64) error {
+	patch, err := encode(key, obj)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, ok := s.lookup(key, time.Now())
+	if !ok {
+		return storage.NewKeyNotFoundError(key, resourceVersion)
+	}
+	if resourceVersion != 0 && resourceVersion != cur.version {
+		return storage.NewResourceVersionConflictsError(key, resourceVersion)
+	}
+
+	data, err := merge(key, cur.data, patch)
+	if err != nil {
+		return err
+	}
+	s.put(key, data, ttl)
+
+	return nil
+}
+
+func (s *store) Upsert(ctx context.Context, key string, resourceVersion int64, update_obj, insert_obj interface{}, ttl uint64) error {
+	if insert_obj == nil {
+		insert_obj = update_obj
+	}
+
+	s.mu.RLock()
+	_, ok := s.lookup(key, time.Now())
+	s.mu.RUnlock()
+
+	if ok {
+		err := s.Update(ctx, key, resourceVersion, update_obj, ttl)
+		if !storage.IsNotFound(err) {
+			return err
+		}
+	}
+
+	err := s.Create(ctx, key, insert_obj, ttl)
+	if storage.IsNodeExist(err) {
+		// lost a race against another writer; retry as an update
+		return s.Update(ctx, key, resourceVersion, update_obj, ttl)
+	}
+	return err
+}
+
+// lookup returns the live item stored under key. The caller must hold s.mu.
+func (s *store) lookup(key string, now time.Time) (*item, bool) {
+	it, ok := s.items[key]
+	if !ok || it.expired(now) {
+		return nil, false
+	}
+	return it, true
+}
+
+// put stores data under key with a new resource version. The caller must hold
+// s.mu for writing.
+func (s *store) put(key string, data []byte, ttl uint64) {
+	now := time.Now()
+	old, _ := s.lookup(key, now)
+
+	s.version++
+	it := &item{data: data, version: s.version}
+	if ttl > 0 {
+		it.expireAt = now.Add(time.Duration(ttl) * time.Second)
+	}
+	s.items[key] = it
+	s.notify(key, old, it)
+}
+
+// remove deletes the item stored under key with a new resource version. The
+// caller must hold s.mu for writing.
+func (s *store) remove(key string, it *item) {
+	delete(s.items, key)
+	s.version++
+	s.notify(key, it, nil)
+}
+
+// match returns the live items at or below key accepted by filter, ordered by
+// key. The caller must hold s.mu.
+func (s *store) match(key string, filter filter, now time.Time) []entry {
+	prefix := strings.TrimSuffix(key, "/")
+	entries := make([]entry, 0)
+	for k, it := range s.items {
+		if it.expired(now) {
+			continue
+		}
+		if prefix != "" && k != prefix && !strings.HasPrefix(k, prefix+"/") {
+			continue
+		}
+		if filter != nil && !filter(it.data) {
+			continue
+		}
+		entries = append(entries, entry{key: k, item: it})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
+
+// expireScrolls drops scroll contexts whose keep alive elapsed. The caller
+// must hold s.mu for writing.
+func (s *store) expireScrolls(now time.Time) {
+	for id, sc := range s.scrolls {
+		if !now.Before(sc.expireAt) {
+			delete(s.scrolls, id)
+		}
+	}
+}
+
+func page(entries []entry, from, size int) []entry {
+	if from >= len(entries) {
+		return entries[:0]
+	}
+	entries = entries[from:]
+	if size > 0 && size < len(entries) {
+		entries = entries[:size]
+	}
+	return entries
+}
+
+func encode(key string, obj interface{}) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, storage.NewInvalidObjError(key, err.Error())
+	}
+	return data, nil
+}
+
+func decode(key string, it *item, out interface{}, p storage.Projection) error {
+	if out == nil {
+		return nil
+	}
+
+	data, err := p.Apply(it.data)
+	if err != nil {
+		return storage.NewInvalidObjError(key, err.Error())
+	}
+	err = json.Unmarshal(data, out)
+	if err != nil {
+		return storage.NewInvalidObjError(key, err.Error())
+	}
+	storage.SetResourceVersion(out, it.version)
+
+	return nil
+}
+
+// merge applies patch to the document in data the way an elasticsearch
+// partial update does: objects are merged recursively, everything else is
+// replaced.
+func merge(key string, data, patch []byte) ([]byte, error) {
+	var doc, p interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, storage.NewInvalidObjError(key, err.Error())
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, storage.NewInvalidObjError(key, err.Error())
+	}
+
+	return encode(key, mergeValue(doc, p))
+}
+
+func mergeValue(doc, patch interface{}) interface{} {
+	doc_map, ok1 := doc.(map[string]interface{})
+	patch_map, ok2 := patch.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return patch
+	}
+
+	for k, v := range patch_map {
+		doc_map[k] = mergeValue(doc_map[k], v)
+	}
+	return doc_map
+}
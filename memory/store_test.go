@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/bingbaba/storage"
+	"github.com/bingbaba/storage/storagetest"
+)
+
+type testObj struct {
+	Code            string `json:"code"`
+	Count           int    `json:"count"`
+	ResourceVersion int64  `json:"-"`
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore()
+
+	testCreate(store, t)
+
+	testGet(store, t)
+
+	testUpdate(store, t)
+
+	testList(store, t)
+
+	testDelete(store, t)
+}
+
+func testCreate(store storage.Interface, t *testing.T) {
+	err := store.Create(context.Background(), "/myindex/mytype/myid", &testObj{Code: "myid"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Create(context.Background(), "/myindex/mytype/myid", &testObj{Code: "myid"}, 0)
+	if !storage.IsNodeExist(err) {
+		t.Fatalf("expect KeyExists error, but get %v", err)
+	}
+}
+
+func testGet(store storage.Interface, t *testing.T) {
+	var obj testObj
+	err := store.Get(context.Background(), "/myindex/mytype/myid", &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Code != "myid" {
+		t.Fatalf("expect \"myid\", but get \"%s\"", obj.Code)
+	}
+	if obj.ResourceVersion == 0 {
+		t.Fatal("resource version not set")
+	}
+
+	err = store.Get(context.Background(), "/myindex/mytype/notexist", &obj)
+	if !storage.IsNotFound(err) {
+		t.Fatalf("expect KeyNotFound error, but get %v", err)
+	}
+}
+
+func testUpdate(store storage.Interface, t *testing.T) {
+	var obj testObj
+	err := store.Get(context.Background(), "/myindex/mytype/myid", &obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(context.Background(), "/myindex/mytype/myid", obj.ResourceVersion,
+		map[string]int{"count": 1}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(context.Background(), "/myindex/mytype/myid", obj.ResourceVersion,
+		map[string]int{"count": 2}, 0)
+	if !storage.IsConflict(err) {
+		t.Fatalf("expect ResourceVersionConflicts error, but get %v", err)
+	}
+
+	var updated testObj
+	err = store.Get(context.Background(), "/myindex/mytype/myid", &updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Code != "myid" || updated.Count != 1 {
+		t.Fatalf("unexpected object after partial update: %+v", updated)
+	}
+}
+
+func testList(store storage.Interface, t *testing.T) {
+	for _, id := range []string{"a", "b", "c"} {
+		err := store.Create(context.Background(), "/myindex/other/"+id, &testObj{Code: id}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := store.List(context.Background(),
+		"/myindex",
+		&storage.SelectionPredicate{Keyword: `code:myid`},
+		&testObj{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].(*testObj).Code != "myid" {
+		t.Fatalf("unexpected list result: %v", list)
+	}
+
+	var count int
+	sp := &storage.SelectionPredicate{ScrollKeepAlive: "1m", Limit: 1}
+	for !sp.EOF {
+		list, err := store.List(context.Background(), "/myindex/other", sp, &testObj{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += len(list)
+	}
+	if count != 3 {
+		t.Fatalf("expect 3 items by scroll, but get %d", count)
+	}
+
+	_, err = store.List(context.Background(), "/myindex/other", sp, &testObj{})
+	if err != io.EOF {
+		t.Fatalf("expect io.EOF after the scroll finished, but get %v", err)
+	}
+}
+
+func testDelete(store storage.Interface, t *testing.T) {
+	err := store.Delete(context.Background(), "/myindex/mytype/myid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, _, err := store.DeleteByQuery(context.Background(), "/myindex/other",
+		map[string]interface{}{"code": []interface{}{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expect 2 deleted, but get %d", deleted)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func() storage.Interface {
+		return NewStore()
+	})
+}
+
+func TestSortMissing(t *testing.T) {
+	store := NewStore()
+	ctx := context.Background()
+	for key, obj := range map[string]interface{}{
+		"/sort/a": map[string]interface{}{"code": "a", "count": 2},
+		"/sort/b": map[string]interface{}{"code": "b"},
+		"/sort/c": map[string]interface{}{"code": "c", "count": 1},
+	} {
+		if err := store.Create(ctx, key, obj, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for missing, want := range map[interface{}]string{
+		storage.SortMissingLast:  "cab",
+		storage.SortMissingFirst: "bca",
+		float64(3):               "cab",
+		float64(0):               "bca",
+	} {
+		sp := &storage.SelectionPredicate{Sort: []storage.SortField{{Field: "count", Missing: missing}}}
+		list, err := store.List(ctx, "/sort", sp, &testObj{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got string
+		for _, item := range list {
+			got += item.(*testObj).Code
+		}
+		if got != want {
+			t.Fatalf("expect %s with missing %v, but get %s", want, missing, got)
+		}
+	}
+
+	sp := &storage.SelectionPredicate{Sort: []storage.SortField{{Field: "count", Missing: []interface{}{3}}}}
+	if list, err := store.List(ctx, "/sort", sp, &testObj{}); err != nil || len(list) != 3 {
+		t.Fatalf("expect a non-comparable missing value to sort, but get %v, %v", list, err)
+	}
+}
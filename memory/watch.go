@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingbaba/storage"
+)
+
+type watcher struct {
+	prefix string
+	filter filter
+
+	mu     sync.Mutex
+	queue  []storage.WatchEvent
+	signal chan struct{}
+}
+
+// Watch reports every write below key as it happens. A non-zero
+// resourceVersion replays objects written after that version as Added events
+// before live changes; deletions that happened in between are not replayed.
+// Expired objects are not reported.
+func (s *store) Watch(ctx context.Context, key string, sp *storage.SelectionPredicate, resourceVersion int64) (<-chan storage.WatchEvent, error) {
+	var keyword interface{}
+	if sp != nil {
+		keyword = sp.Keyword
+	}
+	filter, err := newFilter(keyword)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watcher{
+		prefix: strings.TrimSuffix(key, "/"),
+		filter: filter,
+		signal: make(chan struct{}, 1),
+	}
+
+	s.mu.Lock()
+	for _, e := range s.match(key, filter, time.Now()) {
+		if e.version > resourceVersion {
+			w.push(storage.WatchEvent{Type: storage.Added, Key: e.key, ResourceVersion: e.version, Object: e.data})
+		}
+	}
+	s.watcherSeq++
+	id := s.watcherSeq
+	s.watchers[id] = w
+	s.mu.Unlock()
+
+	c := make(chan storage.WatchEvent)
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.watchers, id)
+			s.mu.Unlock()
+			close(c)
+		}()
+
+		for {
+			for _, e := range w.pop() {
+				select {
+				case c <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-w.signal:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// notify queues the change of key from old to cur for every interested
+// watcher; a nil item means the key did not exist. The caller must hold s.mu
+// for writing.
+func (s *store) notify(key string, old, cur *item) {
+	for _, w := range s.watchers {
+		if w.prefix != "" && key != w.prefix && !strings.HasPrefix(key, w.prefix+"/") {
+			continue
+		}
+
+		wasIn := old != nil && w.accepts(old.data)
+		isIn := cur != nil && w.accepts(cur.data)
+		switch {
+		case !wasIn && isIn:
+			w.push(storage.WatchEvent{Type: storage.Added, Key: key, ResourceVersion: s.version, Object: cur.data})
+		case wasIn && isIn:
+			w.push(storage.WatchEvent{Type: storage.Modified, Key: key, ResourceVersion: s.version, Object: cur.data})
+		case wasIn && !isIn:
+			w.push(storage.WatchEvent{Type: storage.Deleted, Key: key, ResourceVersion: s.version, Object: old.data})
+		}
+	}
+}
+
+func (w *watcher) accepts(data []byte) bool {
+	return w.filter == nil || w.filter(data)
+}
+
+// push queues e without blocking, so writers never wait for slow watchers.
+func (w *watcher) push(e storage.WatchEvent) {
+	w.mu.Lock()
+	w.queue = append(w.queue, e)
+	w.mu.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) pop() []storage.WatchEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events := w.queue
+	w.queue = nil
+	return events
+}
@@ -0,0 +1,13 @@
+package storage
+
+import (
+	"context"
+)
+
+// MultiGetter is implemented by backends that fetch many objects in one
+// call. Each object is decoded into a value returned by newObj, which must
+// be a pointer. Every key ends up in exactly one of the returned maps; keys
+// that do not exist map to a KeyNotFound error.
+type MultiGetter interface {
+	GetMany(ctx context.Context, keys []string, newObj func() interface{}) (map[string]interface{}, map[string]error)
+}
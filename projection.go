@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Projection selects the fields of the objects returned by Get and List,
+// like elasticsearch source filtering. Fields are dotted paths that may use
+// "*" wildcards. When Includes is empty every field not excluded is
+// returned, Excludes take precedence over Includes.
+type Projection struct {
+	Includes []string
+	Excludes []string
+}
+
+// IsEmpty reports whether p returns whole objects.
+func (p Projection) IsEmpty() bool {
+	return len(p.Includes) == 0 && len(p.Excludes) == 0
+}
+
+type projectionKey struct{}
+
+// WithProjection returns a context making Get and GetMany return the fields
+// selected by p only.
+func WithProjection(ctx context.Context, p Projection) context.Context {
+	return context.WithValue(ctx, projectionKey{}, p)
+}
+
+// ProjectionFrom returns the projection of a Get, taken from ctx.
+func ProjectionFrom(ctx context.Context) Projection {
+	p, _ := ctx.Value(projectionKey{}).(Projection)
+	return p
+}
+
+// Projection returns the projection of a List.
+func (sp *SelectionPredicate) Projection() Projection {
+	if sp == nil {
+		return Projection{}
+	}
+	return Projection{Includes: sp.Includes, Excludes: sp.Excludes}
+}
+
+// Apply prunes the JSON document in data to the selected fields, for
+// backends without native source filtering. Documents that are not JSON
+// objects are returned unchanged.
+func (p Projection) Apply(data []byte) ([]byte, error) {
+	if p.IsEmpty() {
+		return data, nil
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+		return data, nil
+	}
+	p.prune(doc, "", len(p.Includes) == 0)
+	return json.Marshal(doc)
+}
+
+// prune removes the fields of doc that are not selected. included is set
+// once an ancestor matched an include pattern.
+func (p Projection) prune(doc map[string]interface{}, prefix string, included bool) {
+	for name, value := range doc {
+		path := prefix + name
+		if matchAny(p.Excludes, path) {
+			delete(doc, name)
+			continue
+		}
+
+		in := included || matchAny(p.Includes, path)
+		if !in && !p.mayIncludeBelow(path) {
+			delete(doc, name)
+			continue
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			p.prune(v, path+".", in)
+			if !in && len(v) == 0 {
+				delete(doc, name)
+			}
+		case []interface{}:
+			kept := v[:0]
+			for _, elem := range v {
+				obj, ok := elem.(map[string]interface{})
+				if !ok {
+					if in {
+						kept = append(kept, elem)
+					}
+					continue
+				}
+				p.prune(obj, path+".", in)
+				if in || len(obj) > 0 {
+					kept = append(kept, obj)
+				}
+			}
+			if !in && len(kept) == 0 {
+				delete(doc, name)
+			} else {
+				doc[name] = kept
+			}
+		default:
+			if !in {
+				delete(doc, name)
+			}
+		}
+	}
+}
+
+// mayIncludeBelow reports whether an include pattern may select a field
+// nested below path.
+func (p Projection) mayIncludeBelow(path string) bool {
+	for _, pattern := range p.Includes {
+		if strings.HasPrefix(pattern, path+".") || strings.Contains(pattern, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if wildcardMatch(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches s against pattern, where "*" matches any sequence of
+// characters, dots included.
+func wildcardMatch(pattern, s string) bool {
+	i := strings.IndexByte(pattern, '*')
+	if i < 0 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, pattern[:i]) {
+		return false
+	}
+
+	rest := pattern[i+1:]
+	for j := i; j <= len(s); j++ {
+		if wildcardMatch(rest, s[j:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestProjection(t *testing.T) {
+	doc := []byte(`{"name":"a","count":1,"user":{"name":"u","email":"e"},"tags":[{"k":"x","v":1},{"k":"y"}],"labels":["l"]}`)
+
+	for _, tt := range []struct {
+		p    Projection
+		want string
+	}{
+		{Projection{}, string(doc)},
+		{Projection{Includes: []string{"name"}}, `{"name":"a"}`},
+		{Projection{Includes: []string{"user.name", "tags.k"}}, `{"tags":[{"k":"x"},{"k":"y"}],"user":{"name":"u"}}`},
+		{Projection{Excludes: []string{"user.email", "tags", "labels"}}, `{"count":1,"name":"a","user":{"name":"u"}}`},
+		{Projection{Includes: []string{"user"}, Excludes: []string{"*.email"}}, `{"user":{"name":"u"}}`},
+		{Projection{Includes: []string{"*name"}}, `{"name":"a","user":{"name":"u"}}`},
+	} {
+		got, err := tt.p.Apply(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("expect %s with %+v, but get %s", tt.want, tt.p, got)
+		}
+	}
+}
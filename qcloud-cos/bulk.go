@@ -0,0 +1,62 @@
+package cos
+
+import (
+	"context"
+
+	"github.com/bingbaba/storage"
+)
+
+// bulkWorkers bounds the concurrent items of one bulk operation. Their
+// requests share the concurrency limit of the store, see limited.
+const bulkWorkers = 16
+
+func (s *store) BulkCreate(ctx context.Context, key string, c chan storage.ChannelObj, ttl uint64) error {
+	summary, err := s.BulkCreateWithOptions(ctx, key, c, ttl, storage.BulkOptions{})
+	if err != nil {
+		return err
+	}
+	return summary.Err()
+}
+
+// BulkCreateWithOptions writes every object under key/<id>, overwriting
+// objects that already exist like the elasticsearch bulk index request.
+func (s *store) BulkCreateWithOptions(ctx context.Context, key string, c chan storage.ChannelObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return storage.RunBulk(ctx, c, bulkWorkers, opts, limited(s, func(ctx context.Context, obj storage.ChannelObj) (string, error) {
+		return obj.Id, s.put(ctx, key+"/"+obj.Id, obj.Data, ttl, nil)
+	}))
+}
+
+func (s *store) BulkUpdate(ctx context.Context, key string, c chan storage.BulkUpdateObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return storage.RunBulk(ctx, c, bulkWorkers, opts, limited(s, func(ctx context.Context, obj storage.BulkUpdateObj) (string, error) {
+		return obj.Id, s.Update(ctx, key+"/"+obj.Id, obj.ResourceVersion, obj.Data, ttl)
+	}))
+}
+
+func (s *store) BulkUpsert(ctx context.Context, key string, c chan storage.BulkUpdateObj, ttl uint64, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return storage.RunBulk(ctx, c, bulkWorkers, opts, limited(s, func(ctx context.Context, obj storage.BulkUpdateObj) (string, error) {
+		return obj.Id, s.Upsert(ctx, key+"/"+obj.Id, obj.ResourceVersion, obj.Data, obj.Insert, ttl)
+	}))
+}
+
+func (s *store) BulkDelete(ctx context.Context, key string, ids chan string, opts storage.BulkOptions) (*storage.BulkSummary, error) {
+	return storage.RunBulk(ctx, ids, bulkWorkers, opts, limited(s, func(ctx context.Context, id string) (string, error) {
+		return id, s.Delete(ctx, key+"/"+id, nil)
+	}))
+}
+
+// limited wraps the bulk function fn of s to hold a slot of the store
+// limiter while it runs. The requests of one item are sequential, so the
+// bulk operations of a store never exceed its concurrency limit together
+// with List, GetMany and the others.
+func limited[T any](s *store, fn func(ctx context.Context, item T) (string, error)) func(context.Context, T) (string, error) {
+	lim := s.limiter()
+	return func(ctx context.Context, item T) (string, error) {
+		epoch, err := lim.acquire(ctx)
+		if err != nil {
+			return "", err
+		}
+		id, err := fn(ctx, item)
+		lim.release(epoch, err)
+		return id, err
+	}
+}
@@ -0,0 +1,64 @@
+package cos
+
+import (
+	"context"
+
+	"github.com/bingbaba/storage"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// Exists reports whether a live object is stored under key with a HEAD
+// request.
+func (s *store) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.head(ctx, key)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Count counts the objects below key by listing their keys. Listings carry
+// no metadata, so expired objects are counted until they are swept. Keywords
+// are evaluated in process like in DeleteByQuery, which fetches the objects
+// unless the keyword refers to KeyField only.
+func (s *store) Count(ctx context.Context, key string, sp *storage.SelectionPredicate) (int64, error) {
+	var keyword interface{}
+	if sp != nil {
+		keyword = sp.Keyword
+	}
+	match, bodies, err := keywordMatcher(keyword)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	opt := &cos.BucketGetOptions{Prefix: parseKey(key)}
+	for {
+		ret, _, err := s.Client.Bucket.Get(ctx, opt)
+		if err != nil {
+			return count, err
+		}
+
+		keys := make([]string, 0, len(ret.Contents))
+		for _, content := range ret.Contents {
+			if content.Key != opt.Prefix {
+				keys = append(keys, content.Key)
+			}
+		}
+		if match != nil {
+			if keys, err = s.matchKeys(ctx, keys, match, bodies); err != nil {
+				return count, err
+			}
+		}
+		count += int64(len(keys))
+
+		marker := nextMarker(ret)
+		if marker == "" {
+			return count, nil
+		}
+		opt.Marker = marker
+	}
+}
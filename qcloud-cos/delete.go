@@ -0,0 +1,192 @@
+package cos
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/bingbaba/storage"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// deleteBatch is the maximum number of objects of a multi-object delete.
+const deleteBatch = 1000
+
+// KeyField is the pseudo field holding the object key, such as "/user/1",
+// when List, DeleteByQuery, Count or Watch evaluate a keyword. Keywords
+// referring to KeyField only are evaluated by DeleteByQuery, Count and Watch
+// without fetching the objects.
+const KeyField = "_key"
+
+// DeleteByQuery deletes the objects below key matching keyword with
+// multi-object deletes. Keywords are evaluated in process on the decoded
+// objects, see KeyField. Objects COS fails to delete are counted as
+// conflicts.
+func (s *store) DeleteByQuery(ctx context.Context, key string, keyword interface{}) (deleted, conflict int64, err error) {
+	match, bodies, err := keywordMatcher(keyword)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	flush := func(batch []cos.Object) error {
+		d, c, err := s.deleteMulti(ctx, batch)
+		deleted += d
+		conflict += c
+		return err
+	}
+
+	batch := make([]cos.Object, 0, deleteBatch)
+	opt := &cos.BucketGetOptions{Prefix: parseKey(key)}
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, conflict, err
+		}
+
+		ret, _, err := s.Client.Bucket.Get(ctx, opt)
+		if err != nil {
+			return deleted, conflict, err
+		}
+
+		keys := make([]string, 0, len(ret.Contents))
+		for _, content := range ret.Contents {
+			if content.Key != opt.Prefix {
+				keys = append(keys, content.Key)
+			}
+		}
+		if match != nil {
+			if keys, err = s.matchKeys(ctx, keys, match, bodies); err != nil {
+				return deleted, conflict, err
+			}
+		}
+
+		for _, k := range keys {
+			batch = append(batch, cos.Object{Key: k})
+			if len(batch) == deleteBatch {
+				if err := flush(batch); err != nil {
+					return deleted, conflict, err
+				}
+				batch = batch[:0]
+			}
+		}
+
+		marker := nextMarker(ret)
+		if marker == "" {
+			break
+		}
+		opt.Marker = marker
+	}
+
+	if len(batch) > 0 {
+		err = flush(batch)
+	}
+	return deleted, conflict, err
+}
+
+// keywordMatcher compiles keyword for in process evaluation. The matcher is
+// nil without a keyword, bodies reports whether it needs the decoded
+// objects rather than KeyField alone.
+func keywordMatcher(keyword interface{}) (match storage.Matcher, bodies bool, err error) {
+	q, err := storage.ParseKeyword(keyword)
+	if err != nil || q == nil {
+		return nil, false, err
+	}
+	if match, err = storage.NewMatcher(q); err != nil {
+		return nil, false, err
+	}
+
+	for _, field := range storage.QueryFields(q) {
+		if field != KeyField {
+			return match, true, nil
+		}
+	}
+	return match, false, nil
+}
+
+// matchObject decodes the body bs of the object stored under key and
+// evaluates match on it, with the key in KeyField.
+func matchObject(match storage.Matcher, key string, bs []byte) (bool, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return false, err
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	doc[KeyField] = key
+	return match(doc), nil
+}
+
+// matchKeys returns the keys whose objects match, see KeyField. With bodies
+// the objects are fetched concurrently, sharing the concurrency limit of the
+// store, and objects that vanished or expired are skipped.
+func (s *store) matchKeys(ctx context.Context, keys []string, match storage.Matcher, bodies bool) ([]string, error) {
+	if !bodies {
+		matched := keys[:0]
+		for _, k := range keys {
+			if match(map[string]interface{}{KeyField: "/" + k}) {
+				matched = append(matched, k)
+			}
+		}
+		return matched, nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	lim := s.limiter()
+	var firstErr error
+	ok := make([]bool, len(keys))
+	for i, k := range keys {
+		epoch, err := lim.acquire(ctx)
+		if err != nil {
+			wg.Wait()
+			return nil, err
+		}
+		wg.Add(1)
+
+		go func(idx int, k string) {
+			defer wg.Done()
+
+			bs, _, err := s.get(ctx, k)
+			lim.release(epoch, err)
+			if err != nil {
+				if !storage.IsNotFound(err) {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+				return
+			}
+
+			ok[idx], _ = matchObject(match, "/"+k, bs)
+		}(i, k)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	matched := make([]string, 0, len(keys))
+	for i, k := range keys {
+		if ok[i] {
+			matched = append(matched, k)
+		}
+	}
+	return matched, nil
+}
+
+// deleteMulti deletes objs with a single multi-object delete and returns the
+// number of objects deleted and failed.
+func (s *store) deleteMulti(ctx context.Context, objs []cos.Object) (deleted, failed int64, err error) {
+	res, _, err := s.Object.DeleteMulti(ctx, &cos.ObjectDeleteMultiOptions{Quiet: true, Objects: objs})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	failed = int64(len(res.Errors))
+	return int64(len(objs)) - failed, failed, nil
+}
@@ -0,0 +1,212 @@
+package cos
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// fakeObject is an object stored by fakeCOS. Like for simple uploads to COS,
+// its ETag is the MD5 of its body.
+type fakeObject struct {
+	body []byte
+	etag string
+	meta http.Header
+}
+
+// fakeCOS is an in-memory COS bucket serving the subset of the API used by
+// the store.
+type fakeCOS struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+
+	// requests counts the requests served by method.
+	requests map[string]int
+
+	// fail, when set, may answer a request with an error status instead of
+	// serving it.
+	fail func(r *http.Request) int
+}
+
+// newFakeStore returns a store backed by a fakeCOS, which is shut down with
+// the test.
+func newFakeStore(t *testing.T) (*store, *fakeCOS) {
+	fake := &fakeCOS{objects: make(map[string]*fakeObject), requests: make(map[string]int)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	return &store{
+		Config: &Config{},
+		Client: cos.NewClient(&cos.BaseURL{BucketURL: u}, srv.Client()),
+	}, fake
+}
+
+func (f *fakeCOS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests[r.Method]++
+	if f.fail != nil {
+		if status := f.fail(r); status != 0 {
+			f.error(w, status, "SlowDown")
+			return
+		}
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		f.deleteMulti(w, r)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for name, values := range obj.meta {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", obj.etag)
+		if r.Method == http.MethodGet {
+			w.Write(obj.body)
+		}
+	case r.Method == http.MethodPut:
+		f.put(w, r, key)
+	case r.Method == http.MethodDelete:
+		if m := r.Header.Get("If-Match"); m != "" && (f.objects[key] == nil || m != f.objects[key].etag) {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeCOS) put(w http.ResponseWriter, r *http.Request, key string) {
+	cur, exists := f.objects[key]
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	if m := r.Header.Get("If-Match"); m != "" && (!exists || m != cur.etag) {
+		f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	sum := md5.Sum(body)
+	obj := &fakeObject{body: body, etag: `"` + hex.EncodeToString(sum[:]) + `"`, meta: make(http.Header)}
+	for name, values := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-cos-meta-") {
+			obj.meta[name] = values
+		}
+	}
+	f.objects[key] = obj
+	w.Header().Set("ETag", obj.etag)
+}
+
+func (f *fakeCOS) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, marker, delimiter := q.Get("prefix"), q.Get("marker"), q.Get("delimiter")
+	maxKeys := 1000
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil && n > 0 {
+		maxKeys = n
+	}
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := cos.BucketGetResult{Prefix: prefix, Marker: marker, Delimiter: delimiter, MaxKeys: maxKeys}
+	seen := make(map[string]bool)
+	last := ""
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= marker {
+			continue
+		}
+
+		entry := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = key[:len(prefix)+i+len(delimiter)]
+				if seen[entry] || entry <= marker {
+					continue
+				}
+			}
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == maxKeys {
+			result.IsTruncated = true
+			if delimiter != "" {
+				result.NextMarker = last
+			}
+			break
+		}
+
+		if entry != key {
+			seen[entry] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, entry)
+		} else {
+			result.Contents = append(result.Contents, cos.Object{Key: key, ETag: f.objects[key].etag})
+		}
+		last = entry
+	}
+
+	body, _ := xml.Marshal(result)
+	w.Write(body)
+}
+
+func (f *fakeCOS) deleteMulti(w http.ResponseWriter, r *http.Request) {
+	var opt cos.ObjectDeleteMultiOptions
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := xml.Unmarshal(body, &opt); err != nil || len(opt.Objects) > 1000 {
+		f.error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var result cos.ObjectDeleteMultiResult
+	for _, obj := range opt.Objects {
+		delete(f.objects, obj.Key)
+		if !opt.Quiet {
+			result.DeletedObjects = append(result.DeletedObjects, cos.Object{Key: obj.Key})
+		}
+	}
+	body, _ = xml.Marshal(result)
+	w.Write(body)
+}
+
+func (f *fakeCOS) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, http.StatusText(status))
+}
+
+// keys returns the keys of the stored objects in order.
+func (f *fakeCOS) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,103 @@
+package cos
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// DefaultConcurrency is the number of concurrent requests of a store
+// fanning out, such as the GETs of List, when Config.Concurrency is zero.
+const DefaultConcurrency = 50
+
+// limiter bounds the concurrent requests of one store. With adaptive
+// throttling the limit halves when COS answers 503 SlowDown and grows back
+// by one after every limit successful requests, up to max.
+type limiter struct {
+	mu       sync.Mutex
+	max      int
+	limit    int
+	active   int
+	adaptive bool
+
+	// successes counts the successful requests since the limit last changed.
+	successes int
+
+	// epoch is bumped whenever the limit is lowered, so that the requests
+	// of one burst that were all throttled lower it only once.
+	epoch int
+
+	// freed is closed and replaced whenever a request finishes.
+	freed chan struct{}
+}
+
+func newLimiter(max int, adaptive bool) *limiter {
+	if max <= 0 {
+		max = DefaultConcurrency
+	}
+	return &limiter{max: max, limit: max, adaptive: adaptive, freed: make(chan struct{})}
+}
+
+// limiter returns the limiter of s, created from its Config on first use.
+func (s *store) limiter() *limiter {
+	s.limiterOnce.Do(func() {
+		s.lim = newLimiter(s.Config.Concurrency, s.Config.AdaptiveThrottling)
+	})
+	return s.lim
+}
+
+// acquire waits until another request may start. The returned epoch is to
+// be passed to release.
+func (l *limiter) acquire(ctx context.Context) (int, error) {
+	for {
+		l.mu.Lock()
+		if l.active < l.limit {
+			l.active++
+			epoch := l.epoch
+			l.mu.Unlock()
+			return epoch, nil
+		}
+		freed := l.freed
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// release ends a request started in epoch, which failed with err.
+func (l *limiter) release(epoch int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	if l.adaptive {
+		switch {
+		case isSlowDown(err):
+			if epoch == l.epoch && l.limit > 1 {
+				l.limit /= 2
+				l.epoch++
+			}
+			l.successes = 0
+		case err == nil && l.limit < l.max:
+			l.successes++
+			if l.successes >= l.limit {
+				l.limit++
+				l.successes = 0
+			}
+		}
+	}
+
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+func isSlowDown(err error) bool {
+	e, ok := cos.IsCOSError(err)
+	return ok && (e.Code == "SlowDown" || e.Response != nil && e.Response.StatusCode == http.StatusServiceUnavailable)
+}
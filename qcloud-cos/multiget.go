@@ -0,0 +1,52 @@
+package cos
+
+import (
+	"context"
+	"sync"
+)
+
+// GetMany fetches the objects stored under keys with concurrent GET
+// requests, sharing the concurrency limit of the store.
+func (s *store) GetMany(ctx context.Context, keys []string, newObj func() interface{}) (map[string]interface{}, map[string]error) {
+	objs := make(map[string]interface{}, len(keys))
+	errs := make(map[string]error)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	lim := s.limiter()
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		epoch, err := lim.acquire(ctx)
+		if err != nil {
+			mu.Lock()
+			errs[key] = err
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+
+		go func(key string) {
+			defer wg.Done()
+
+			obj := newObj()
+			err := s.Get(ctx, key, obj)
+			lim.release(epoch, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err
+			} else {
+				objs[key] = obj
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	return objs, errs
+}
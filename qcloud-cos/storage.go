@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,13 +20,12 @@ import (
 	"github.com/tencentyun/cos-go-sdk-v5"
 )
 
-var (
-	asyncLimit = make(chan bool, 50)
-)
-
 type store struct {
 	*Config
 	*cos.Client
+
+	limiterOnce sync.Once
+	lim         *limiter
 }
 
 func NewStorage(conf *Config) *store {
@@ -54,6 +54,18 @@ type Config struct {
 	SecretKey string
 	Bucket    string
 	Region    string
+
+	// WatchInterval is how often Watch lists the bucket for changes,
+	// DefaultWatchInterval when zero.
+	WatchInterval time.Duration
+
+	// Concurrency bounds the concurrent requests of one store fanning out,
+	// such as the GETs of List, DefaultConcurrency when zero.
+	Concurrency int
+
+	// AdaptiveThrottling lowers the concurrency of a store when COS answers
+	// 503 SlowDown and raises it back as requests succeed.
+	AdaptiveThrottling bool
 }
 
 func NewConfigByEnv() *Config {
@@ -63,44 +75,112 @@ func NewConfigByEnv() *Config {
 	}
 
 	return &Config{
-		os.Getenv("QCLOUD_APPID"),
-		os.Getenv("QCLOUD_SID"),
-		os.Getenv("QCLOUD_SKEY"),
-		os.Getenv("QCLOUD_BUCKET"),
-		region,
+		AppID:     os.Getenv("QCLOUD_APPID"),
+		SecretId:  os.Getenv("QCLOUD_SID"),
+		SecretKey: os.Getenv("QCLOUD_SKEY"),
+		Bucket:    os.Getenv("QCLOUD_BUCKET"),
+		Region:    region,
 	}
 }
 
 func (s *store) Get(ctx context.Context, key string, out interface{}) error {
+	bs, version, err := s.get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if out != nil {
+		return decode(ctx, bs, version, out)
+	}
+
+	return nil
+}
+
+// get reads the object stored under key and returns its body and resource
+// version.
+func (s *store) get(ctx context.Context, key string) ([]byte, int64, error) {
+	bs, header, err := s.getObject(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bs, etagVersion(header.Get("ETag")), nil
+}
+
+// getObject reads the object stored under key and returns its body and
+// response header. Missing and expired objects give KeyNotFound, expired
+// ones along with their header.
+func (s *store) getObject(ctx context.Context, key string) ([]byte, http.Header, error) {
 	opt := &cos.ObjectGetOptions{
 		ResponseContentType: contentType(ctx),
 	}
-	resp, err := s.Object.Get(context.Background(), parseKey(key), opt)
+	resp, err := s.Object.Get(ctx, parseKey(key), opt)
 	if err != nil {
 		if strings.Index(err.Error(), "NoSuchKey") >= 0 {
-			return storage.NewKeyNotFoundError(key, 0)
+			return nil, nil, storage.NewKeyNotFoundError(key, 0)
 		} else {
-			return err
+			return nil, nil, err
 		}
 	}
 	defer resp.Body.Close()
 
-	if out != nil {
-		bs, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
+	if isExpired(resp.Header) {
+		return nil, resp.Header, storage.NewKeyNotFoundError(key, 0).WithCause(errExpired)
+	}
 
-		err = json.Unmarshal(bs, out)
-		if err != nil {
-			return err
-		}
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bs, resp.Header, nil
+}
+
+// decode prunes bs to the projection of ctx and decodes it into out.
+func decode(ctx context.Context, bs []byte, version int64, out interface{}) error {
+	bs, err := storage.ProjectionFrom(ctx).Apply(bs)
+	if err != nil {
+		return err
 	}
 
+	err = json.Unmarshal(bs, out)
+	if err != nil {
+		return err
+	}
+	storage.SetResourceVersion(out, version)
 	return nil
 }
 
+// Create fails with KeyExists when a live object is stored under key. An
+// expired object that was not swept yet is overwritten.
 func (s *store) Create(ctx context.Context, key string, obj interface{}, ttl uint64) error {
+	resp, err := s.Object.Head(ctx, parseKey(key), nil)
+	if err == nil {
+		if !isExpired(resp.Header) {
+			return storage.NewKeyExistsError(key, etagVersion(resp.Header.Get("ETag")))
+		}
+
+		err = s.put(ctx, key, obj, ttl, http.Header{"If-Match": []string{resp.Header.Get("ETag")}})
+		if isPreconditionFailed(err) {
+			return storage.NewKeyExistsError(key, 0)
+		}
+		return err
+	}
+	if !cos.IsNotFoundError(err) {
+		return err
+	}
+
+	// guard against a writer racing with the HEAD request
+	err = s.put(ctx, key, obj, ttl, http.Header{
+		"If-None-Match":          []string{"*"},
+		"x-cos-forbid-overwrite": []string{"true"},
+	})
+	if isPreconditionFailed(err) || isConflict(err) {
+		return storage.NewKeyExistsError(key, 0)
+	}
+	return err
+}
+
+// put writes obj under key, sending the extra request headers in header.
+func (s *store) put(ctx context.Context, key string, obj interface{}, ttl uint64, header http.Header) error {
 	var reader io.Reader
 	reader, ok := obj.(io.Reader)
 	if !ok {
@@ -122,16 +202,13 @@ func (s *store) Create(ctx context.Context, key string, obj interface{}, ttl uin
 		},
 	}
 
-	_, err := s.Object.Put(ctx, parseKey(key), reader, opt)
-	return err
-}
-
-func (s *store) BulkCreate(ctx context.Context, key string, c chan storage.ChannelObj, ttl uint64) error {
-	for obj := range c {
-		s.Create(ctx, key+"/"+obj.Id, obj.Data, 0)
+	setExpiry(opt.ObjectPutHeaderOptions, ttl)
+	if header != nil {
+		opt.ObjectPutHeaderOptions.XOptionHeader = &header
 	}
 
-	return nil
+	_, err := s.Object.Put(ctx, parseKey(key), reader, opt)
+	return err
 }
 
 func (s *store) Delete(ctx context.Context, key string, out interface{}) error {
@@ -139,28 +216,83 @@ func (s *store) Delete(ctx context.Context, key string, out interface{}) error {
 	return err
 }
 
-func (s *store) DeleteByQuery(ctx context.Context, key string, keyword interface{}) (deleted, conflict int64, err error) {
-	return 0, 0, nil
+// List fetches the listed objects concurrently. Objects that could not be
+// fetched or decoded, including those deleted since the listing, are
+// reported by a *storage.ListError returned along with the others. A
+// Delimiter is rejected, since List cannot return the common prefixes; use
+// ListDir instead.
+func (s *store) List(ctx context.Context, key string, sp *storage.SelectionPredicate, obj interface{}) ([]interface{}, error) {
+	if sp != nil && sp.Delimiter != "" {
+		return nil, storage.NewBadRequestError("List cannot list by delimiter, use ListDir")
+	}
+
+	list, _, err := s.list(ctx, key, sp, obj, "")
+	return list, err
 }
 
-func (s *store) List(ctx context.Context, key string, sp *storage.SelectionPredicate, obj interface{}) ([]interface{}, error) {
+// ListDir lists the objects directly below key and, separately, the common
+// prefixes of the deeper keys, such as "user/<id>/" when listing "/user".
+// The delimiter is sp.Delimiter, "/" when empty. Limit and the scroll pages
+// count objects and prefixes together.
+func (s *store) ListDir(ctx context.Context, key string, sp *storage.SelectionPredicate, obj interface{}) (*storage.DirListing, error) {
+	delimiter := "/"
+	if sp != nil && sp.Delimiter != "" {
+		delimiter = sp.Delimiter
+	}
 
+	// a *ListError comes with a partial listing
+	items, prefixes, err := s.list(ctx, key, sp, obj, delimiter)
+	if err != nil && !storage.IsListError(err) {
+		return nil, err
+	}
+	return &storage.DirListing{Items: items, Prefixes: prefixes}, err
+}
+
+// list lists the objects below key. With a delimiter only the objects
+// directly below key are listed, the deeper keys are returned as common
+// prefixes.
+func (s *store) list(ctx context.Context, key string, sp *storage.SelectionPredicate, obj interface{}, delimiter string) ([]interface{}, []string, error) {
 	opt := &cos.BucketGetOptions{
 		Prefix: parseKey(key),
 	}
 
 	if sp != nil {
-		if sp.ScrollId != "" {
-			opt.Prefix = sp.ScrollId
+		if len(sp.Sort) > 0 {
+			return nil, nil, storage.NewBadRequestError("the COS store lists in key order and cannot sort")
+		}
+		if sp.SearchAfter {
+			return nil, nil, storage.NewBadRequestError("the COS store does not support cursor paging")
 		}
 		if sp.Limit > 0 {
 			opt.MaxKeys = sp.Limit
 		}
 	}
+	if delimiter != "" {
+		// list the children of key rather than key itself
+		opt.Delimiter = delimiter
+		if opt.Prefix != "" && !strings.HasSuffix(opt.Prefix, delimiter) {
+			opt.Prefix += delimiter
+		}
+	}
+
+	// a scroll pages through the listing with the continuation marker,
+	// which is carried in ScrollId
+	scroll := sp != nil && (sp.ScrollKeepAlive != "" || sp.ScrollId != "")
+	if scroll {
+		if sp.EOF {
+			sp.ScrollId = ""
+			return nil, nil, io.EOF
+		}
+		opt.Marker = sp.ScrollId
+	}
 
 	ret, _, err := s.Client.Bucket.Get(ctx, opt)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if scroll {
+		sp.ScrollId = nextMarker(ret)
+		sp.EOF = sp.ScrollId == ""
 	}
 
 	// U: 去掉与Prefix相同的Key
@@ -172,54 +304,144 @@ func (s *store) List(ctx context.Context, key string, sp *storage.SelectionPredi
 		contents = append(contents, content)
 	}
 
+	// KeyOnly listings carry no metadata, so they include expired objects
+	// until they are swept
 	resp := make([]interface{}, len(contents))
 	if sp != nil && sp.KeyOnly {
 		for i, c := range contents {
 			resp[i] = c.Key
 		}
 
-		return resp, nil
+		return resp, ret.CommonPrefixes, nil
 	} else {
 		if obj == nil {
-			return nil, storage.NewBadRequestError("non-pointer")
+			return nil, nil, storage.NewBadRequestError("non-pointer")
 		}
 		if reflect.TypeOf(obj).Kind() != reflect.Ptr {
-			return nil, storage.NewBadRequestError("non-pointer " + reflect.TypeOf(obj).String())
+			return nil, nil, storage.NewBadRequestError("non-pointer " + reflect.TypeOf(obj).String())
 		}
 
+		// keywords are evaluated in process on every fetched object, so
+		// pages may hold fewer than Limit objects
+		var keyword interface{}
+		if sp != nil {
+			keyword = sp.Keyword
+		}
+		match, _, err := keywordMatcher(keyword)
+		if err != nil {
+			return nil, nil, err
+		}
+		getCtx := storage.WithProjection(ctx, sp.Projection())
+
 		var wg sync.WaitGroup
+		lim := s.limiter()
+		gone := make([]bool, len(contents))
+		errs := make([]error, len(contents))
 		for i, c := range contents {
-			select {
-			case <-ctx.Done():
-				return resp, ctx.Err()
-			case asyncLimit <- true:
-				wg.Add(1)
+			epoch, err := lim.acquire(ctx)
+			if err != nil {
+				wg.Wait()
+				return resp, ret.CommonPrefixes, err
 			}
+			wg.Add(1)
 
 			go func(idx int, c_tmp cos.Object) {
-				defer func() {
-					wg.Done()
-					<-asyncLimit
-				}()
+				defer wg.Done()
+				key := "/" + c_tmp.Key
+				bs, version, err := s.get(ctx, key)
+				lim.release(epoch, err)
+				if err != nil {
+					// expired objects are absent, objects deleted since
+					// the listing are reported
+					if errors.Is(err, errExpired) {
+						gone[idx] = true
+					} else {
+						errs[idx] = err
+					}
+					return
+				}
+				if match != nil {
+					ok, err := matchObject(match, key, bs)
+					if err != nil {
+						errs[idx] = storage.NewInvalidObjError(key, err.Error())
+						return
+					}
+					if !ok {
+						gone[idx] = true
+						return
+					}
+				}
+
 				new_obj := reflect.New(reflect.TypeOf(obj).Elem()).Interface()
-				err := s.Get(ctx, c_tmp.Key, new_obj)
-				if err == nil {
-					resp[idx] = new_obj
+				if err := decode(getCtx, bs, version, new_obj); err != nil {
+					errs[idx] = storage.NewInvalidObjError(key, err.Error())
+					return
 				}
+				resp[idx] = new_obj
 			}(i, c)
 		}
 		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return resp, ret.CommonPrefixes, err
+		}
+
+		// drop objects that expired or do not match the keyword, and the
+		// failed ones when asked to
+		listErr := &storage.ListError{Listed: len(contents)}
+		live := resp[:0]
+		for i, item := range resp {
+			if errs[i] != nil {
+				listErr.Failed = append(listErr.Failed, storage.ListFailure{Key: "/" + contents[i].Key, Err: errs[i]})
+				if sp != nil && sp.SkipFailed {
+					continue
+				}
+			}
+			if !gone[i] {
+				live = append(live, item)
+			}
+		}
+		resp = live
+		if len(listErr.Failed) > 0 {
+			return resp, ret.CommonPrefixes, listErr
+		}
 	}
-	return resp, nil
+	return resp, ret.CommonPrefixes, nil
 
 }
 
+// Update replaces the object stored under key if its resource version still
+// matches resourceVersion, zero matching any version. The check is
+// best-effort, see etagVersion and replace.
 func (s *store) Update(ctx context.Context, key string, resourceVersion int64, obj interface{}, ttl uint64) error {
-	return s.Create(ctx, key, obj, ttl)
+	return s.replace(ctx, key, resourceVersion, obj, ttl)
 }
 
 func (s *store) Upsert(ctx context.Context, key string, resourceVersion int64, update_obj, insert_obj interface{}, ttl uint64) error {
-	return s.Create(ctx, key, update_obj, ttl)
+	if insert_obj == nil {
+		insert_obj = update_obj
+	}
+
+	err := s.replace(ctx, key, resourceVersion, update_obj, ttl)
+	if storage.IsNotFound(err) {
+		return s.Create(ctx, key, insert_obj, ttl)
+	}
+	return err
+}
+
+// nextMarker returns the marker continuing a truncated listing, or "" after
+// the last page. COS only returns NextMarker for listings with a delimiter,
+// otherwise the listing continues after its last key.
+func nextMarker(ret *cos.BucketGetResult) string {
+	if !ret.IsTruncated {
+		return ""
+	}
+	if ret.NextMarker != "" {
+		return ret.NextMarker
+	}
+	if len(ret.Contents) > 0 {
+		return ret.Contents[len(ret.Contents)-1].Key
+	}
+	return ""
 }
 
 func parseKey(key string) string {
@@ -3,8 +3,16 @@ package cos
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/bingbaba/storage"
+	"github.com/bingbaba/storage/storagetest"
+	"github.com/tencentyun/cos-go-sdk-v5"
 )
 
 func TestCos(t *testing.T) {
@@ -54,3 +62,635 @@ func TestCos(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// conformanceOptions relaxes the suite for resource versions derived from
+// ETags, which change on every write without increasing.
+var conformanceOptions = storagetest.Options{UnorderedResourceVersions: true}
+
+func TestConformance(t *testing.T) {
+	if os.Getenv("QCLOUD_BUCKET") == "" {
+		t.Skip("QCLOUD_BUCKET is not set")
+	}
+
+	storagetest.RunConformanceWithOptions(t, func() storage.Interface {
+		return NewStorage(NewConfigByEnv())
+	}, conformanceOptions)
+}
+
+func TestEtagVersion(t *testing.T) {
+	v := etagVersion(`"d41d8cd98f00b204e9800998ecf8427e"`)
+	if v <= 0 {
+		t.Fatalf("expect a positive resource version, but get %d", v)
+	}
+	if v != etagVersion("d41d8cd98f00b204e9800998ecf8427e") {
+		t.Fatal("expect quoted and unquoted ETags to give the same resource version")
+	}
+	if v == etagVersion(`"9e107d9d372bb6826bd81d3542a419d6"`) {
+		t.Fatal("expect different ETags to give different resource versions")
+	}
+	if etagVersion("") != 0 {
+		t.Fatal("expect no resource version without an ETag")
+	}
+}
+
+func TestListScroll(t *testing.T) {
+	s, _ := newFakeStore(t)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err := s.Create(ctx, fmt.Sprintf("/scroll/%d", i), map[string]int{"n": i}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sp := &storage.SelectionPredicate{ScrollKeepAlive: "1m", Limit: 2}
+	var keys []string
+	for pages := 0; !sp.EOF; pages++ {
+		if pages == 3 {
+			t.Fatal("expect the listing to end after 3 pages")
+		}
+		sp.KeyOnly = pages%2 == 0
+		list, err := s.List(ctx, "/scroll", sp, &map[string]int{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range list {
+			if key, ok := item.(string); ok {
+				keys = append(keys, key)
+			} else {
+				keys = append(keys, fmt.Sprintf("scroll/%v", (*item.(*map[string]int))["n"]))
+			}
+		}
+	}
+	if fmt.Sprint(keys) != "[scroll/0 scroll/1 scroll/2 scroll/3 scroll/4]" {
+		t.Fatalf("expect every key once in order, but get %v", keys)
+	}
+
+	if _, err := s.List(ctx, "/scroll", sp, &map[string]int{}); err != io.EOF {
+		t.Fatalf("expect io.EOF after the last page, but get %v", err)
+	}
+	if sp.ScrollId != "" {
+		t.Fatalf("expect the scroll id to be reset, but get %q", sp.ScrollId)
+	}
+}
+
+func TestListDir(t *testing.T) {
+	s, _ := newFakeStore(t)
+	ctx := context.Background()
+	for _, key := range []string{"/user/1/a", "/user/1/b", "/user/2/a", "/user/3/x/y", "/user/readme", "/users/9/a"} {
+		if err := s.Create(ctx, key, map[string]string{"key": key}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := s.ListDir(ctx, "/user", nil, &map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(dir.Prefixes) != "[user/1/ user/2/ user/3/]" {
+		t.Fatalf("expect the user prefixes, but get %v", dir.Prefixes)
+	}
+	if len(dir.Items) != 1 || (*dir.Items[0].(*map[string]string))["key"] != "/user/readme" {
+		t.Fatalf("expect the readme object only, but get %v", dir.Items)
+	}
+
+	sp := &storage.SelectionPredicate{ScrollKeepAlive: "1m", Limit: 2, KeyOnly: true}
+	var entries []string
+	for pages := 0; !sp.EOF; pages++ {
+		if pages == 3 {
+			t.Fatal("expect the directory listing to end after 2 pages")
+		}
+		dir, err := s.ListDir(ctx, "/user/", sp, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, dir.Prefixes...)
+		for _, item := range dir.Items {
+			entries = append(entries, item.(string))
+		}
+	}
+	if fmt.Sprint(entries) != "[user/1/ user/2/ user/3/ user/readme]" {
+		t.Fatalf("expect every entry once, but get %v", entries)
+	}
+
+	if _, err := s.List(ctx, "/user", &storage.SelectionPredicate{Delimiter: "/"}, &map[string]string{}); !storage.IsBadRequest(err) {
+		t.Fatalf("expect List to reject a delimiter, but get %v", err)
+	}
+}
+
+func TestFakeConformance(t *testing.T) {
+	storagetest.RunConformanceWithOptions(t, func() storage.Interface {
+		s, _ := newFakeStore(t)
+		s.WatchInterval = 100 * time.Millisecond
+		return s
+	}, conformanceOptions)
+}
+
+func TestDeleteByQuery(t *testing.T) {
+	s, fake := newFakeStore(t)
+	ctx := context.Background()
+	for i := 0; i < 1203; i++ {
+		fake.objects[fmt.Sprintf("batch/%04d", i)] = &fakeObject{body: []byte(fmt.Sprintf(`{"n":%d}`, i)), etag: `"e"`}
+	}
+	fake.objects["batchx/0"] = &fakeObject{body: []byte(`{"n":0}`), etag: `"e"`}
+
+	deleted, conflict, err := s.DeleteByQuery(ctx, "/batch/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1203 || conflict != 0 {
+		t.Fatalf("expect 1203 deleted and no conflicts, but get %d and %d", deleted, conflict)
+	}
+	if fake.requests["POST"] != 2 {
+		t.Fatalf("expect 2 multi-object deletes, but get %d", fake.requests["POST"])
+	}
+	if keys := fake.keys(); fmt.Sprint(keys) != "[batchx/0]" {
+		t.Fatalf("expect the objects outside the prefix to remain, but get %v", keys)
+	}
+
+	for i := 0; i < 6; i++ {
+		if err := s.Create(ctx, fmt.Sprintf("/filter/%d", i), map[string]int{"n": i}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gets := fake.requests["GET"]
+	deleted, _, err = s.DeleteByQuery(ctx, "/filter", storage.Prefix(KeyField, "/filter/1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 || fake.requests["GET"] != gets+1 {
+		t.Fatalf("expect one key deleted without fetching the objects, but get %d deleted and %d GETs",
+			deleted, fake.requests["GET"]-gets)
+	}
+
+	deleted, _, err = s.DeleteByQuery(ctx, "/filter", storage.Range("n").GreaterOrEqual(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.keys(); deleted != 3 || fmt.Sprint(keys) != "[batchx/0 filter/0 filter/2]" {
+		t.Fatalf("expect the objects with n >= 3 deleted, but get %d deleted and %v left", deleted, keys)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := s.DeleteByQuery(cancelled, "/filter", nil); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, but get %v", err)
+	}
+	if keys := fake.keys(); len(keys) != 3 {
+		t.Fatalf("expect nothing deleted after cancellation, but get %v left", keys)
+	}
+
+	// fetches in flight are abandoned once ctx is done
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodGet && r.URL.Path != "/" {
+			time.Sleep(time.Second)
+		}
+		return 0
+	}
+	cancelled, cancel = context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := s.DeleteByQuery(cancelled, "/filter", storage.Exists("n")); err != context.DeadlineExceeded {
+		t.Fatalf("expect context.DeadlineExceeded, but get %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expect DeleteByQuery to return on cancellation, but it took %v", elapsed)
+	}
+}
+
+func TestListFailures(t *testing.T) {
+	s, fake := newFakeStore(t)
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		if err := s.Create(ctx, fmt.Sprintf("/fail/%d", i), map[string]int{"n": i}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.objects["fail/1"].body = []byte("{")
+	fake.objects["fail/2"].meta.Set(expireAtMeta, "1")
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodGet && r.URL.Path == "/fail/3" {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+
+	list, err := s.List(ctx, "/fail", nil, &map[string]int{})
+	listErr, ok := err.(*storage.ListError)
+	if !ok {
+		t.Fatalf("expect a *storage.ListError, but get %v", err)
+	}
+	if listErr.Listed != 4 || len(listErr.Failed) != 2 ||
+		listErr.Failed[0].Key != "/fail/1" || !storage.IsInvalidObj(listErr.Failed[0].Err) ||
+		listErr.Failed[1].Key != "/fail/3" {
+		t.Fatalf("expect fail/1 and fail/3 to fail, but get %+v", listErr)
+	}
+	if len(list) != 3 || list[0] == nil || list[1] != nil || list[2] != nil {
+		t.Fatalf("expect nil entries for the failed objects and none for the expired one, but get %v", list)
+	}
+
+	list, err = s.List(ctx, "/fail", &storage.SelectionPredicate{SkipFailed: true}, &map[string]int{})
+	if !storage.IsListError(err) {
+		t.Fatalf("expect a *storage.ListError, but get %v", err)
+	}
+	if len(list) != 1 || (*list[0].(*map[string]int))["n"] != 0 {
+		t.Fatalf("expect only the healthy object, but get %v", list)
+	}
+	if err := s.Get(ctx, listErr.Failed[0].Key, &map[string]interface{}{}); err == nil || storage.IsNotFound(err) {
+		t.Fatalf("expect the failed key to be usable with Get, but get %v", err)
+	}
+	if (&storage.ListError{}).Error() == "" {
+		t.Fatal("expect an empty ListError to describe itself")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	s, fake := newFakeStore(t)
+	s.Concurrency = 8
+	s.AdaptiveThrottling = true
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		if err := s.Create(ctx, fmt.Sprintf("/slow/%02d", i), map[string]int{"n": i}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	other, _ := newFakeStore(t)
+	if other.limiter() == s.limiter() {
+		t.Fatal("expect every store to have its own limiter")
+	}
+
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodGet && r.URL.Path != "/" {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	if _, err := s.List(ctx, "/slow", nil, &map[string]int{}); !storage.IsListError(err) {
+		t.Fatalf("expect a *storage.ListError, but get %v", err)
+	}
+	lim := s.limiter()
+	if lim.limit >= 8 {
+		t.Fatalf("expect SlowDown to lower the concurrency, but get %d", lim.limit)
+	}
+
+	fake.fail = nil
+	for i := 0; i < 10 && lim.limit < 8; i++ {
+		if _, err := s.List(ctx, "/slow", nil, &map[string]int{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lim.limit != 8 {
+		t.Fatalf("expect the concurrency to recover to 8, but get %d", lim.limit)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	lim := newLimiter(2, false)
+	ctx := context.Background()
+	e1, _ := lim.acquire(ctx)
+	lim.acquire(ctx)
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := lim.acquire(timeout); err != context.DeadlineExceeded {
+		t.Fatalf("expect acquire to wait for a free slot, but get %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		lim.acquire(ctx)
+		close(done)
+	}()
+	lim.release(e1, nil)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expect release to wake up a waiting acquire")
+	}
+}
+
+func TestWatchExpiry(t *testing.T) {
+	s, _ := newFakeStore(t)
+	s.WatchInterval = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Create(ctx, "/expiring/a", map[string]string{"name": "a"}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := s.Watch(ctx, "/expiring", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []storage.EventType
+	timeout := time.After(3 * time.Second)
+	for len(types) < 2 {
+		select {
+		case e := <-c:
+			if e.Type == storage.Error {
+				t.Fatal(e.Err)
+			}
+			types = append(types, e.Type)
+		case <-timeout:
+			t.Fatalf("expect the object to be added and to expire, but get %v", types)
+		}
+	}
+	if types[0] != storage.Added || types[1] != storage.Deleted {
+		t.Fatalf("expect ADDED and DELETED, but get %v", types)
+	}
+}
+
+func TestKeyword(t *testing.T) {
+	s, _ := newFakeStore(t)
+	s.WatchInterval = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i, n := range []int{1, 5, 8} {
+		if err := s.Create(ctx, fmt.Sprintf("/kw/%d", i), map[string]int{"n": n}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := s.Count(ctx, "/kw", &storage.SelectionPredicate{Keyword: storage.Range("n").GreaterOrEqual(3)})
+	if err != nil || count != 2 {
+		t.Fatalf("expect 2 objects with n >= 3, but get %d, %v", count, err)
+	}
+	count, err = s.Count(ctx, "/kw", &storage.SelectionPredicate{Keyword: storage.Eq(KeyField, "/kw/0")})
+	if err != nil || count != 1 {
+		t.Fatalf("expect 1 object with the key /kw/0, but get %d, %v", count, err)
+	}
+	list, err := s.List(ctx, "/kw", &storage.SelectionPredicate{Keyword: storage.Eq(KeyField, "/kw/0")}, &map[string]int{})
+	if err != nil || len(list) != 1 || (*list[0].(*map[string]int))["n"] != 1 {
+		t.Fatalf("expect List to return the object with the key /kw/0, but get %v, %v", list, err)
+	}
+
+	c, err := s.Watch(ctx, "/kw", &storage.SelectionPredicate{Keyword: storage.Range("n").GreaterOrEqual(3)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() storage.WatchEvent {
+		select {
+		case e := <-c:
+			if e.Type == storage.Error {
+				t.Fatal(e.Err)
+			}
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("expect a watch event")
+		}
+		return storage.WatchEvent{}
+	}
+
+	added := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		e := next()
+		if e.Type != storage.Added {
+			t.Fatalf("expect ADDED, but get %s %s", e.Type, e.Key)
+		}
+		added[e.Key] = true
+	}
+	if !added["/kw/1"] || !added["/kw/2"] {
+		t.Fatalf("expect the matching objects to be added, but get %v", added)
+	}
+
+	if err := s.Update(ctx, "/kw/1", 0, map[string]int{"n": 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Type != storage.Deleted || e.Key != "/kw/1" {
+		t.Fatalf("expect /kw/1 to be deleted once it stops matching, but get %s %s", e.Type, e.Key)
+	}
+
+	if err := s.Update(ctx, "/kw/0", 0, map[string]int{"n": 3}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Type != storage.Added || e.Key != "/kw/0" {
+		t.Fatalf("expect /kw/0 to be added once it matches, but get %s %s", e.Type, e.Key)
+	}
+}
+
+func TestUpdateIfMatch(t *testing.T) {
+	s, fake := newFakeStore(t)
+	ctx := context.Background()
+	if err := s.Create(ctx, "/race/a", map[string]int{"n": 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	if err := s.Get(ctx, "/race/a", &out); err != nil {
+		t.Fatal(err)
+	}
+
+	// another writer replaces the object between the HEAD and the PUT
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodPut && r.Header.Get("If-Match") != "" {
+			fake.objects["race/a"] = &fakeObject{body: []byte(`{"n":2}`), etag: `"other"`, meta: make(http.Header)}
+		}
+		return 0
+	}
+
+	err := s.Update(ctx, "/race/a", storage.GetResourceVersion(&out), map[string]int{"n": 3}, 0)
+	if !storage.IsConflict(err) {
+		t.Fatalf("expect a conflict when If-Match is stale, but get %v", err)
+	}
+	if body := string(fake.objects["race/a"].body); body != `{"n":2}` {
+		t.Fatalf("expect the concurrent write to be kept, but get %s", body)
+	}
+}
+
+func TestSweeperErrors(t *testing.T) {
+	s, fake := newFakeStore(t)
+	fake.fail = func(r *http.Request) int {
+		return http.StatusInternalServerError
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	s.StartSweeper(ctx, "/sweep", 10*time.Millisecond, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expect the error of the failed pass")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect a failed sweep to be reported")
+	}
+}
+
+// countingTransport records the highest number of concurrent requests.
+type countingTransport struct {
+	mu          sync.Mutex
+	active, max int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.active++
+	if t.active > t.max {
+		t.max = t.active
+	}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.active--
+		t.mu.Unlock()
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestBulkConcurrency(t *testing.T) {
+	fs, _ := newFakeStore(t)
+	transport := &countingTransport{}
+	s := &store{
+		Config: &Config{Concurrency: 4},
+		Client: cos.NewClient(fs.BaseURL, &http.Client{Transport: transport}),
+	}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		c := make(chan storage.ChannelObj)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.BulkCreate(ctx, fmt.Sprintf("/bulk/%d", i), c, 0); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			for j := 0; j < 40; j++ {
+				c <- storage.ChannelObj{Id: fmt.Sprint(j), Data: map[string]int{"n": j}}
+			}
+			close(c)
+		}()
+	}
+
+	keys := make([]string, 40)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/bulk/missing/%d", i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.GetMany(ctx, keys, func() interface{} { return &map[string]int{} })
+	}()
+	wg.Wait()
+
+	if transport.max > 4 {
+		t.Fatalf("expect at most 4 concurrent requests, but get %d", transport.max)
+	}
+}
+
+func TestCreateExists(t *testing.T) {
+	s, fake := newFakeStore(t)
+	ctx := context.Background()
+	if err := s.Create(ctx, "/exists/a", map[string]int{"n": 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, "/exists/a", map[string]int{"n": 2}, 0); !storage.IsNodeExist(err) {
+		t.Fatalf("expect KeyExists for a live object, but get %v", err)
+	}
+
+	// another writer replaces the expired object before it is overwritten
+	fake.objects["exists/a"].meta.Set(expireAtMeta, "1")
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodPut && r.Header.Get("If-Match") != "" {
+			fake.objects["exists/a"] = &fakeObject{body: []byte(`{"n":3}`), etag: `"other"`, meta: make(http.Header)}
+		}
+		return 0
+	}
+	if err := s.Create(ctx, "/exists/a", map[string]int{"n": 2}, 0); !storage.IsNodeExist(err) {
+		t.Fatalf("expect KeyExists when the expired object changes concurrently, but get %v", err)
+	}
+
+	fake.fail = nil
+	fake.objects["exists/a"].meta.Set(expireAtMeta, "1")
+	if err := s.Create(ctx, "/exists/a", map[string]int{"n": 2}, 0); err != nil {
+		t.Fatalf("expect the expired object to be replaced, but get %v", err)
+	}
+	if body := string(fake.objects["exists/a"].body); body != `{"n":2}` {
+		t.Fatalf("expect the new object, but get %s", body)
+	}
+
+	// another writer creates the object between the HEAD and the PUT
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodPut && r.Header.Get("If-None-Match") == "*" {
+			fake.objects["exists/b"] = &fakeObject{body: []byte(`{"n":3}`), etag: `"other"`, meta: make(http.Header)}
+		}
+		return 0
+	}
+	if err := s.Create(ctx, "/exists/b", map[string]int{"n": 2}, 0); !storage.IsNodeExist(err) {
+		t.Fatalf("expect KeyExists when the object is created concurrently, but get %v", err)
+	}
+}
+
+func TestSweepExpired(t *testing.T) {
+	s, fake := newFakeStore(t)
+	ctx := context.Background()
+	for _, key := range []string{"/sweep/live", "/sweep/expired", "/sweep/rewritten"} {
+		if err := s.Create(ctx, key, map[string]string{"key": key}, 60); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Create(ctx, "/sweep/forever", map[string]string{"key": "forever"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	fake.objects["sweep/expired"].meta.Set(expireAtMeta, "1")
+	fake.objects["sweep/rewritten"].meta.Set(expireAtMeta, "1")
+
+	// another writer rewrites an expired object between the HEAD and the DELETE
+	fake.fail = func(r *http.Request) int {
+		if r.Method == http.MethodDelete && r.URL.Path == "/sweep/rewritten" {
+			fake.objects["sweep/rewritten"] = &fakeObject{body: []byte(`{}`), etag: `"fresh"`, meta: make(http.Header)}
+		}
+		return 0
+	}
+
+	deleted, err := s.SweepExpired(ctx, "/sweep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.keys(); deleted != 1 || fmt.Sprint(keys) != "[sweep/forever sweep/live sweep/rewritten]" {
+		t.Fatalf("expect only the expired object to be deleted, but get %d deleted and %v left", deleted, keys)
+	}
+	if fake.objects["sweep/rewritten"].etag != `"fresh"` {
+		t.Fatal("expect the rewritten object to be kept")
+	}
+}
+
+func TestUpdateSameContent(t *testing.T) {
+	s, _ := newFakeStore(t)
+	ctx := context.Background()
+	if err := s.Create(ctx, "/aba/a", map[string]int{"n": 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	stale := map[string]interface{}{}
+	if err := s.Get(ctx, "/aba/a", &stale); err != nil {
+		t.Fatal(err)
+	}
+
+	// versions follow the content, so A→B→A brings back the version of A and
+	// a compare-and-swap holding it still succeeds
+	for _, n := range []int{2, 1} {
+		if err := s.Update(ctx, "/aba/a", 0, map[string]int{"n": n}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := map[string]interface{}{}
+	if err := s.Get(ctx, "/aba/a", &out); err != nil {
+		t.Fatal(err)
+	}
+	if v := storage.GetResourceVersion(&stale); v == 0 || storage.GetResourceVersion(&out) != v {
+		t.Fatal("expect rewriting the content to bring back its resource version")
+	}
+	if err := s.Update(ctx, "/aba/a", storage.GetResourceVersion(&stale), map[string]int{"n": 3}, 0); err != nil {
+		t.Fatalf("expect the update with the version of the same content to succeed, but get %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package cos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bingbaba/storage"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// expireAtMeta is the object metadata holding the expiry time of objects
+// written with a ttl, in seconds since the epoch.
+const expireAtMeta = "x-cos-meta-expire-at"
+
+// setExpiry adds the Expires header and the expiry metadata for ttl to opt.
+func setExpiry(opt *cos.ObjectPutHeaderOptions, ttl uint64) {
+	if ttl == 0 {
+		return
+	}
+
+	at := time.Now().Add(time.Duration(ttl) * time.Second)
+	opt.Expires = at.UTC().Format(http.TimeFormat)
+	if opt.XCosMetaXXX == nil {
+		opt.XCosMetaXXX = &http.Header{}
+	}
+	opt.XCosMetaXXX.Set(expireAtMeta, strconv.FormatInt(at.Unix(), 10))
+}
+
+// errExpired is the cause of the KeyNotFound errors of expired objects that
+// were not swept yet.
+var errExpired = errors.New("object expired")
+
+// isExpired reports whether the object described by header has expired.
+func isExpired(header http.Header) bool {
+	v := header.Get(expireAtMeta)
+	if v == "" {
+		return false
+	}
+
+	at, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return false
+	}
+	return at <= time.Now().Unix()
+}
+
+// TTL reads the remaining ttl of the object stored under key from its
+// expiry metadata.
+func (s *store) TTL(ctx context.Context, key string) (uint64, error) {
+	header, err := s.head(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	at, err := strconv.ParseInt(header.Get(expireAtMeta), 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+	return storage.RemainingTTL(time.Unix(at, 0)), nil
+}
+
+// SweepExpired deletes the expired objects below key. Listings carry no
+// metadata, so every object is inspected with a HEAD request, and deleted
+// only if its ETag still matches, so that an object rewritten since the HEAD
+// is kept. On buckets ignoring If-Match on DELETE, a write landing between
+// the HEAD and the DELETE is lost.
+func (s *store) SweepExpired(ctx context.Context, key string) (int64, error) {
+	var deleted int64
+	opt := &cos.BucketGetOptions{Prefix: parseKey(key)}
+	for {
+		ret, _, err := s.Client.Bucket.Get(ctx, opt)
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, content := range ret.Contents {
+			if err := ctx.Err(); err != nil {
+				return deleted, err
+			}
+
+			resp, err := s.Object.Head(ctx, content.Key, nil)
+			if err != nil {
+				if cos.IsNotFoundError(err) {
+					continue
+				}
+				return deleted, err
+			}
+			if !isExpired(resp.Header) {
+				continue
+			}
+
+			header := http.Header{"If-Match": []string{resp.Header.Get("ETag")}}
+			_, err = s.Object.Delete(ctx, content.Key, &cos.ObjectDeleteOptions{XOptionHeader: &header})
+			if err != nil {
+				if isPreconditionFailed(err) || cos.IsNotFoundError(err) {
+					continue
+				}
+				return deleted, err
+			}
+			deleted++
+		}
+
+		marker := nextMarker(ret)
+		if marker == "" {
+			return deleted, nil
+		}
+		opt.Marker = marker
+	}
+}
+
+// StartSweeper runs SweepExpired on key every interval until ctx is done.
+// Failed passes are reported to onError, which may be nil.
+func (s *store) StartSweeper(ctx context.Context, key string, interval time.Duration, onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.SweepExpired(ctx, key); err != nil && onError != nil && ctx.Err() == nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package cos
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"strings"
+
+	"github.com/bingbaba/storage"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// etagVersion derives the resource version of an object from its ETag. The
+// version changes whenever the content does but, unlike elasticsearch
+// versions, it does not increase monotonically. The ETag is the MD5 of the
+// content, so writing earlier content again brings back its version too, and
+// a compare-and-swap holding the version from before an A→B→A sequence of
+// writes succeeds. Optimistic concurrency on COS is best-effort.
+func etagVersion(etag string) int64 {
+	etag = strings.Trim(etag, `"`)
+	if etag == "" {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(etag))
+	v := int64(h.Sum64() >> 1)
+	if v == 0 {
+		v = 1
+	}
+	return v
+}
+
+// head returns the headers of the live object stored under key.
+func (s *store) head(ctx context.Context, key string) (http.Header, error) {
+	resp, err := s.Object.Head(ctx, parseKey(key), nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil, storage.NewKeyNotFoundError(key, 0)
+		}
+		return nil, err
+	}
+	if isExpired(resp.Header) {
+		return nil, storage.NewKeyNotFoundError(key, 0)
+	}
+
+	return resp.Header, nil
+}
+
+// replace overwrites the object stored under key if its resource version
+// still matches resourceVersion, zero matching any version. The check is
+// repeated by COS through If-Match, so a write racing with the HEAD request
+// fails with a precondition error instead of being lost. On a bucket that
+// ignores If-Match only the HEAD check remains, and of two writes racing
+// within the HEAD and PUT round trip the last one wins.
+func (s *store) replace(ctx context.Context, key string, resourceVersion int64, obj interface{}, ttl uint64) error {
+	header, err := s.head(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	etag := header.Get("ETag")
+	if resourceVersion != 0 && etagVersion(etag) != resourceVersion {
+		return storage.NewResourceVersionConflictsError(key, resourceVersion)
+	}
+
+	err = s.put(ctx, key, obj, ttl, http.Header{"If-Match": []string{etag}})
+	if isPreconditionFailed(err) {
+		return storage.NewResourceVersionConflictsError(key, resourceVersion)
+	}
+	return err
+}
+
+func isPreconditionFailed(err error) bool {
+	e, ok := cos.IsCOSError(err)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusPreconditionFailed
+}
+
+func isConflict(err error) bool {
+	e, ok := cos.IsCOSError(err)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusConflict
+}
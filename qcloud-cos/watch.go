@@ -0,0 +1,149 @@
+package cos
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bingbaba/storage"
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+const DefaultWatchInterval = 10 * time.Second
+
+// Watch lists the objects below key every Config.WatchInterval and reports
+// the differences between consecutive listings based on the object ETags.
+// Keywords are evaluated in process like in DeleteByQuery: every new or
+// changed object is fetched during the poll, and an object that stops
+// matching is reported as deleted. Any non-zero resourceVersion starts
+// watching from the current state.
+func (s *store) Watch(ctx context.Context, key string, sp *storage.SelectionPredicate, resourceVersion int64) (<-chan storage.WatchEvent, error) {
+	interval := s.Config.WatchInterval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	prefix := parseKey(key)
+
+	var keyword interface{}
+	if sp != nil {
+		keyword = sp.Keyword
+	}
+	match, bodies, err := keywordMatcher(keyword)
+	if err != nil {
+		return nil, err
+	}
+
+	// listings carry no metadata, so what a fetch revealed about an object
+	// version is remembered until its ETag changes, and the object drops
+	// out of the polls once it expired
+	var mu sync.Mutex
+	seen := make(map[string]*watchObject)
+
+	load := func(ctx context.Context, key string) (*watchObject, []byte, error) {
+		bs, header, err := s.getObject(ctx, key)
+		if header == nil {
+			return nil, nil, err
+		}
+
+		o := &watchObject{tag: header.Get("ETag")}
+		o.expireAt, _ = strconv.ParseInt(header.Get(expireAtMeta), 10, 64)
+		if err == nil && match != nil {
+			if o.match, _ = matchObject(match, key, bs); o.match {
+				o.body = bs
+			}
+		}
+
+		mu.Lock()
+		seen[key] = o
+		mu.Unlock()
+		return o, bs, err
+	}
+
+	poll := func(ctx context.Context) (map[string]storage.WatchState, error) {
+		states := make(map[string]storage.WatchState)
+		listed := make(map[string]string)
+		opt := &cos.BucketGetOptions{Prefix: prefix}
+		now := time.Now().Unix()
+		for {
+			ret, _, err := s.Client.Bucket.Get(ctx, opt)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, content := range ret.Contents {
+				if content.Key == prefix {
+					continue
+				}
+
+				key := "/" + content.Key
+				listed[key] = content.ETag
+				if match != nil && !bodies && !match(map[string]interface{}{KeyField: key}) {
+					continue
+				}
+
+				mu.Lock()
+				o := seen[key]
+				mu.Unlock()
+				if o != nil && o.tag != content.ETag {
+					o = nil
+				}
+				if o == nil && bodies {
+					if o, _, err = load(ctx, key); o == nil {
+						if storage.IsNotFound(err) {
+							continue
+						}
+						return nil, err
+					}
+				}
+
+				if o != nil && o.expireAt > 0 && o.expireAt <= now {
+					continue
+				}
+				state := storage.WatchState{
+					ResourceVersion: etagVersion(content.ETag),
+					Tag:             content.ETag,
+				}
+				if bodies {
+					if !o.match {
+						continue
+					}
+					state.Object = o.body
+				}
+				states[key] = state
+			}
+
+			marker := nextMarker(ret)
+			if marker == "" {
+				break
+			}
+			opt.Marker = marker
+		}
+
+		mu.Lock()
+		for key, o := range seen {
+			if tag, ok := listed[key]; !ok || tag != o.tag {
+				delete(seen, key)
+			}
+		}
+		mu.Unlock()
+		return states, nil
+	}
+
+	fetch := func(ctx context.Context, key string) ([]byte, error) {
+		_, bs, err := load(ctx, key)
+		return bs, err
+	}
+
+	return storage.PollWatch(ctx, interval, resourceVersion, poll, fetch)
+}
+
+// watchObject is what a fetch revealed about one version of an object: its
+// expiry time in seconds since the epoch and, with a keyword, whether it
+// matches.
+type watchObject struct {
+	tag      string
+	expireAt int64
+	match    bool
+	body     []byte
+}
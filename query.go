@@ -0,0 +1,678 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a backend-neutral selection of documents, accepted as the keyword
+// of List, Count, Watch and DeleteByQuery. The elasticsearch store compiles
+// it to a query, stores without a query engine evaluate it in process with
+// NewMatcher. Fields are dotted paths such as "user.name".
+type Query interface {
+	compile() (Matcher, error)
+}
+
+// Matcher reports whether a decoded JSON document matches a query.
+type Matcher func(doc map[string]interface{}) bool
+
+// NewMatcher compiles q for evaluation in process. Raw queries cannot be
+// evaluated and are rejected with BadRequest. A nil query matches every
+// document.
+func NewMatcher(q Query) (Matcher, error) {
+	if q == nil {
+		return func(map[string]interface{}) bool { return true }, nil
+	}
+	return q.compile()
+}
+
+// ParseKeyword converts a keyword to a Query. Besides a Query it accepts the
+// legacy keyword forms: a query string, and a map of fields to a term, a
+// list of terms, a range or a raw elasticsearch query clause, which must all
+// match. A range is a map of the operators gt, gte, lt and lte, with an
+// optional format and time_zone, such as
+//
+//	{"createdAt": {"gte": "now-7d/d", "lt": "now/d", "time_zone": "+08:00"}}
+//
+// An empty keyword yields a nil Query.
+func ParseKeyword(keyword interface{}) (Query, error) {
+	switch v := keyword.(type) {
+	case nil:
+		return nil, nil
+	case Query:
+		return v, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return QueryString(v), nil
+	case map[string]interface{}:
+		queries := make([]Query, 0, len(v))
+		for field, value := range v {
+			switch v2 := value.(type) {
+			case string, int, int64, float64, bool:
+				queries = append(queries, Eq(field, v2))
+			case []interface{}:
+				queries = append(queries, In(field, v2...))
+			case map[string]interface{}:
+				if isRangeClause(v2) {
+					queries = append(queries, rangeFromClause(field, v2))
+				} else {
+					queries = append(queries, Raw(v2))
+				}
+			default:
+				return nil, NewBadRequestError(fmt.Sprintf("unsupported keyword clause for field %q", field))
+			}
+		}
+		return And(queries...), nil
+	default:
+		typ_str := reflect.TypeOf(keyword).Kind().String()
+		return nil, NewBadRequestError("unknown keyword argument: " + typ_str)
+	}
+}
+
+var rangeClauseKeys = map[string]bool{
+	"gt": true, "gte": true, "lt": true, "lte": true, "format": true, "time_zone": true,
+}
+
+// isRangeClause reports whether a keyword map value is a range rather than a
+// raw query clause.
+func isRangeClause(clause map[string]interface{}) bool {
+	bounded := false
+	for k := range clause {
+		if !rangeClauseKeys[k] {
+			return false
+		}
+		if k != "format" && k != "time_zone" {
+			bounded = true
+		}
+	}
+	return bounded
+}
+
+func rangeFromClause(field string, clause map[string]interface{}) *RangeQuery {
+	q := &RangeQuery{Field: field, Gt: clause["gt"], Gte: clause["gte"], Lt: clause["lt"], Lte: clause["lte"]}
+	q.Format, _ = clause["format"].(string)
+	q.TimeZone, _ = clause["time_zone"].(string)
+	return q
+}
+
+// EqQuery matches documents whose field equals Value, or contains it when
+// the field is an array.
+type EqQuery struct {
+	Field string
+	Value interface{}
+}
+
+func Eq(field string, value interface{}) *EqQuery {
+	return &EqQuery{Field: field, Value: value}
+}
+
+func (q *EqQuery) compile() (Matcher, error) {
+	return func(doc map[string]interface{}) bool {
+		return termMatch(LookupField(doc, q.Field), q.Value)
+	}, nil
+}
+
+// InQuery matches documents whose field equals any of Values.
+type InQuery struct {
+	Field  string
+	Values []interface{}
+}
+
+func In(field string, values ...interface{}) *InQuery {
+	return &InQuery{Field: field, Values: values}
+}
+
+func (q *InQuery) compile() (Matcher, error) {
+	return func(doc map[string]interface{}) bool {
+		return termMatch(LookupField(doc, q.Field), q.Values)
+	}, nil
+}
+
+// RangeQuery matches documents whose field lies within the bounds that are
+// set. Numbers compare numerically and strings lexically, except that bounds
+// given as time.Time or as dates, optionally with elasticsearch date math
+// such as "now-1d/d", compare as times against date strings and epoch
+// milliseconds in the document.
+type RangeQuery struct {
+	Field string
+	Gt    interface{}
+	Gte   interface{}
+	Lt    interface{}
+	Lte   interface{}
+
+	// Format is the elasticsearch date format of the bounds. In process only
+	// ISO 8601 dates are understood.
+	Format string
+
+	// TimeZone applies to bounds without a zone and to date math rounding,
+	// an offset such as "+01:00" or a location name. UTC when empty.
+	TimeZone string
+}
+
+func Range(field string) *RangeQuery {
+	return &RangeQuery{Field: field}
+}
+
+// TimeWindow matches documents whose date field lies in [from, to).
+func TimeWindow(field string, from, to time.Time) *RangeQuery {
+	return Range(field).GreaterOrEqual(from).LessThan(to)
+}
+
+func (q *RangeQuery) GreaterThan(v interface{}) *RangeQuery {
+	q.Gt = v
+	return q
+}
+
+func (q *RangeQuery) GreaterOrEqual(v interface{}) *RangeQuery {
+	q.Gte = v
+	return q
+}
+
+func (q *RangeQuery) LessThan(v interface{}) *RangeQuery {
+	q.Lt = v
+	return q
+}
+
+func (q *RangeQuery) LessOrEqual(v interface{}) *RangeQuery {
+	q.Lte = v
+	return q
+}
+
+func (q *RangeQuery) WithFormat(format string) *RangeQuery {
+	q.Format = format
+	return q
+}
+
+func (q *RangeQuery) WithTimeZone(tz string) *RangeQuery {
+	q.TimeZone = tz
+	return q
+}
+
+// rangeBound is a bound of a RangeQuery resolved for evaluation.
+type rangeBound struct {
+	value interface{}
+	ok    func(c int) bool
+}
+
+func (q *RangeQuery) compile() (Matcher, error) {
+	if q.Gt == nil && q.Gte == nil && q.Lt == nil && q.Lte == nil {
+		return nil, NewBadRequestError(fmt.Sprintf("range on field %q has no bounds", q.Field))
+	}
+	loc, err := loadTimeZone(q.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve once to report invalid date math before evaluating
+	bounds, dynamic, err := q.bounds(time.Now(), loc)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc map[string]interface{}) bool {
+		bs := bounds
+		if dynamic {
+			bs, _, _ = q.bounds(time.Now(), loc)
+		}
+		return anyValue(LookupField(doc, q.Field), func(v interface{}) bool {
+			return rangeContains(bs, v, loc)
+		})
+	}, nil
+}
+
+// bounds resolves the bounds that are set, converting dates and date math to
+// time.Time. dynamic reports whether a bound depends on now.
+func (q *RangeQuery) bounds(now time.Time, loc *time.Location) (bounds []rangeBound, dynamic bool, err error) {
+	for _, b := range []struct {
+		value   interface{}
+		roundUp bool
+		ok      func(c int) bool
+	}{
+		{q.Gt, true, func(c int) bool { return c > 0 }},
+		{q.Gte, false, func(c int) bool { return c >= 0 }},
+		{q.Lt, false, func(c int) bool { return c < 0 }},
+		{q.Lte, true, func(c int) bool { return c <= 0 }},
+	} {
+		if b.value == nil {
+			continue
+		}
+
+		value := b.value
+		if s, ok := value.(string); ok {
+			if isDateMath(s) {
+				t, err := parseDateMath(s, now, loc, b.roundUp)
+				if err != nil {
+					return nil, false, err
+				}
+				value = t
+				dynamic = dynamic || strings.HasPrefix(s, "now")
+			} else if t, ok := parseDate(s, loc); ok {
+				value = t
+			}
+		}
+		bounds = append(bounds, rangeBound{value: value, ok: b.ok})
+	}
+	return bounds, dynamic, nil
+}
+
+func rangeContains(bounds []rangeBound, value interface{}, loc *time.Location) bool {
+	for _, b := range bounds {
+		var c int
+		if t, ok := b.value.(time.Time); ok {
+			v, ok := toTime(value, loc)
+			if !ok {
+				return false
+			}
+			switch {
+			case v.Before(t):
+				c = -1
+			case v.After(t):
+				c = 1
+			}
+		} else {
+			var ok bool
+			if c, ok = compareScalars(value, b.value); !ok {
+				return false
+			}
+		}
+		if !b.ok(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// toTime converts a document value to a time, dates being stored as strings
+// or as epoch milliseconds.
+func toTime(value interface{}, loc *time.Location) (time.Time, bool) {
+	if s, ok := value.(string); ok {
+		return parseDate(s, loc)
+	}
+	if f, ok := toFloat(value); ok {
+		return time.UnixMilli(int64(f)), true
+	}
+	return time.Time{}, false
+}
+
+// PrefixQuery matches documents whose string field starts with Prefix.
+type PrefixQuery struct {
+	Field  string
+	Prefix string
+}
+
+func Prefix(field, prefix string) *PrefixQuery {
+	return &PrefixQuery{Field: field, Prefix: prefix}
+}
+
+func (q *PrefixQuery) compile() (Matcher, error) {
+	return func(doc map[string]interface{}) bool {
+		return anyValue(LookupField(doc, q.Field), func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && strings.HasPrefix(s, q.Prefix)
+		})
+	}, nil
+}
+
+// ExistsQuery matches documents with a non-null value in field.
+type ExistsQuery struct {
+	Field string
+}
+
+func Exists(field string) *ExistsQuery {
+	return &ExistsQuery{Field: field}
+}
+
+func (q *ExistsQuery) compile() (Matcher, error) {
+	return func(doc map[string]interface{}) bool {
+		return anyValue(LookupField(doc, q.Field), func(v interface{}) bool { return true })
+	}, nil
+}
+
+// MatchQuery is a full-text query matching documents whose field shares a
+// word with Text, ignoring case.
+type MatchQuery struct {
+	Field string
+	Text  string
+}
+
+func Match(field, text string) *MatchQuery {
+	return &MatchQuery{Field: field, Text: text}
+}
+
+func (q *MatchQuery) compile() (Matcher, error) {
+	words := make(map[string]bool)
+	for _, w := range tokenize(q.Text) {
+		words[w] = true
+	}
+
+	return func(doc map[string]interface{}) bool {
+		return anyValue(LookupField(doc, q.Field), func(v interface{}) bool {
+			for _, w := range tokenize(fmt.Sprintf("%v", v)) {
+				if words[w] {
+					return true
+				}
+			}
+			return false
+		})
+	}, nil
+}
+
+// QueryStringQuery is an elasticsearch query string. In process only the
+// "field:value" and bare term subset of the syntax is evaluated, the value
+// being a single term or a quoted phrase. Operators, wildcards and the rest
+// of the syntax fail with BadRequest.
+type QueryStringQuery struct {
+	Query string
+}
+
+func QueryString(query string) *QueryStringQuery {
+	return &QueryStringQuery{Query: query}
+}
+
+func (q *QueryStringQuery) compile() (Matcher, error) {
+	query := strings.TrimSpace(q.Query)
+	if query == "" || query == "*" {
+		return func(map[string]interface{}) bool { return true }, nil
+	}
+
+	field, value, err := splitQueryString(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(doc map[string]interface{}) bool {
+		if field != "" {
+			return textMatch(LookupField(doc, field), value)
+		}
+		for _, v := range doc {
+			if textMatch(v, value) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// splitQueryString splits a query string of the supported subset into its
+// field, empty for a bare term, and its unquoted value.
+func splitQueryString(query string) (field, value string, err error) {
+	value = query
+	if idx := strings.Index(query, ":"); idx > 0 {
+		field, value = query[:idx], query[idx+1:]
+	}
+
+	unsupported := NewBadRequestError(fmt.Sprintf("query string %q is outside the field:value subset this store evaluates", query))
+	if strings.ContainsAny(field, queryStringSpecial+" \t") || strings.ContainsAny(query[:1], "+-") {
+		return "", "", unsupported
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+		if strings.ContainsAny(value, `"\`) {
+			return "", "", unsupported
+		}
+		return field, value, nil
+	}
+	if value == "" || strings.ContainsAny(value, queryStringSpecial+" \t\n") {
+		return "", "", unsupported
+	}
+	return field, value, nil
+}
+
+// queryStringSpecial are the characters of the query string syntax outside
+// the supported subset.
+const queryStringSpecial = `*?~^()[]{}"\:/<>=!&|`
+
+// RawQuery is an elasticsearch query clause passed through unchanged. It
+// cannot be evaluated in process.
+type RawQuery struct {
+	Source map[string]interface{}
+}
+
+func Raw(source map[string]interface{}) *RawQuery {
+	return &RawQuery{Source: source}
+}
+
+func (q *RawQuery) compile() (Matcher, error) {
+	return nil, NewBadRequestError("raw elasticsearch queries cannot be evaluated by this store")
+}
+
+// AndQuery matches documents matching all of Queries.
+type AndQuery struct {
+	Queries []Query
+}
+
+func And(queries ...Query) *AndQuery {
+	return &AndQuery{Queries: queries}
+}
+
+func (q *AndQuery) compile() (Matcher, error) {
+	matchers, err := compileAll(q.Queries)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc map[string]interface{}) bool {
+		for _, m := range matchers {
+			if !m(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// OrQuery matches documents matching any of Queries.
+type OrQuery struct {
+	Queries []Query
+}
+
+func Or(queries ...Query) *OrQuery {
+	return &OrQuery{Queries: queries}
+}
+
+func (q *OrQuery) compile() (Matcher, error) {
+	matchers, err := compileAll(q.Queries)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc map[string]interface{}) bool {
+		for _, m := range matchers {
+			if m(doc) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// NotQuery matches documents not matching Query.
+type NotQuery struct {
+	Query Query
+}
+
+func Not(query Query) *NotQuery {
+	return &NotQuery{Query: query}
+}
+
+func (q *NotQuery) compile() (Matcher, error) {
+	m, err := NewMatcher(q.Query)
+	if err != nil {
+		return nil, err
+	}
+	return func(doc map[string]interface{}) bool {
+		return !m(doc)
+	}, nil
+}
+
+func compileAll(queries []Query) ([]Matcher, error) {
+	matchers := make([]Matcher, len(queries))
+	for i, q := range queries {
+		m, err := NewMatcher(q)
+		if err != nil {
+			return nil, err
+		}
+		matchers[i] = m
+	}
+	return matchers, nil
+}
+
+// QueryFields returns the fields q refers to. An empty field stands for
+// clauses that may look at any field, such as bare query string terms and
+// raw queries.
+func QueryFields(q Query) []string {
+	switch v := q.(type) {
+	case nil:
+		return nil
+	case *EqQuery:
+		return []string{v.Field}
+	case *InQuery:
+		return []string{v.Field}
+	case *RangeQuery:
+		return []string{v.Field}
+	case *PrefixQuery:
+		return []string{v.Field}
+	case *ExistsQuery:
+		return []string{v.Field}
+	case *MatchQuery:
+		return []string{v.Field}
+	case *QueryStringQuery:
+		query := strings.TrimSpace(v.Query)
+		if query == "" || query == "*" {
+			return nil
+		}
+		field, _, _ := splitQueryString(query)
+		return []string{field}
+	case *AndQuery:
+		return queriesFields(v.Queries)
+	case *OrQuery:
+		return queriesFields(v.Queries)
+	case *NotQuery:
+		return QueryFields(v.Query)
+	default:
+		return []string{""}
+	}
+}
+
+func queriesFields(queries []Query) []string {
+	fields := make([]string, 0, len(queries))
+	for _, q := range queries {
+		fields = append(fields, QueryFields(q)...)
+	}
+	return fields
+}
+
+// LookupField resolves a dotted field path such as "user.name" in a decoded
+// JSON document.
+func LookupField(doc map[string]interface{}, field string) interface{} {
+	var cur interface{} = doc
+	for _, name := range strings.Split(field, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[name]
+	}
+	return cur
+}
+
+// anyValue reports whether fn accepts the non-null value, or any element of
+// the array value.
+func anyValue(value interface{}, fn func(v interface{}) bool) bool {
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if anyValue(v, fn) {
+				return true
+			}
+		}
+		return false
+	}
+	return value != nil && fn(value)
+}
+
+// termMatch reports whether the document value equals want. Arrays in the
+// document match when any element does, and a []interface{} want matches any
+// of its values, like elasticsearch term and terms queries.
+func termMatch(value, want interface{}) bool {
+	if wants, ok := want.([]interface{}); ok {
+		for _, w := range wants {
+			if termMatch(value, w) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return anyValue(value, func(v interface{}) bool {
+		if c, ok := compareScalars(v, want); ok {
+			return c == 0
+		}
+		return fmt.Sprintf("%v", v) == fmt.Sprintf("%v", want)
+	})
+}
+
+func textMatch(value interface{}, want string) bool {
+	return anyValue(value, func(v interface{}) bool {
+		return strings.EqualFold(fmt.Sprintf("%v", v), want)
+	})
+}
+
+// compareScalars compares two numbers or two strings.
+func compareScalars(a, b interface{}) (int, bool) {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	sa, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+	sb, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+	return strings.Compare(sa, sb), true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(`{"name":"Alice Smith","age":30,"tags":["a","b"],"user":{"id":"u-1"},"empty":null,"createdAt":"2024-03-14T15:09:26Z","updatedAt":1710428966000}`), &doc)
+	day := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		q    Query
+		want bool
+	}{
+		{nil, true},
+		{Eq("age", 30), true},
+		{Eq("tags", "b"), true},
+		{Eq("user.id", "u-2"), false},
+		{In("tags", "c", "a"), true},
+		{Range("age").GreaterThan(29).LessOrEqual(30), true},
+		{Range("age").LessThan(30), false},
+		{Range("user.id").GreaterOrEqual("u-0"), true},
+		{TimeWindow("createdAt", day, day.AddDate(0, 0, 1)), true},
+		{TimeWindow("createdAt", day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)), false},
+		{Range("createdAt").GreaterThan("2024-03-14").WithTimeZone("+08:00"), true},
+		{Range("createdAt").LessOrEqual("2024-03-14||/d").WithTimeZone("-08:00"), true},
+		{Range("createdAt").LessThan("2024-03-14||/d").WithTimeZone("-08:00"), false},
+		{Range("updatedAt").GreaterOrEqual(day).LessThan("now"), true},
+		{Range("createdAt").GreaterThan("now"), false},
+		{Prefix("user.id", "u-"), true},
+		{Exists("user.id"), true},
+		{Exists("empty"), false},
+		{Match("name", "bob smith"), true},
+		{Match("name", "bob"), false},
+		{QueryString("name:\"alice smith\""), true},
+		{QueryString("*"), true},
+		{QueryString("user.id:U-1"), true},
+		{QueryString("30"), true},
+		{And(Eq("age", 30), Prefix("name", "Bob")), false},
+		{Or(Eq("age", 31), Prefix("name", "Ali")), true},
+		{Not(Exists("missing")), true},
+	} {
+		m, err := NewMatcher(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m(doc); got != tt.want {
+			t.Errorf("expect %v for %#v, but get %v", tt.want, tt.q, got)
+		}
+	}
+
+	if _, err := NewMatcher(And(Raw(map[string]interface{}{"match_all": map[string]interface{}{}}))); !IsBadRequest(err) {
+		t.Errorf("expect BadRequest error for a raw query, but get %v", err)
+	}
+	if _, err := NewMatcher(Range("age")); !IsBadRequest(err) {
+		t.Errorf("expect BadRequest error for a range without bounds, but get %v", err)
+	}
+	for _, query := range []string{"status:active AND name:x", "name:ali*", "name:alice~", "-name:bob", "(a OR b)", "age:[1 TO 5]", "name:"} {
+		if _, err := NewMatcher(QueryString(query)); !IsBadRequest(err) {
+			t.Errorf("expect BadRequest error for the query string %q, but get %v", query, err)
+		}
+	}
+}
+
+func TestParseKeyword(t *testing.T) {
+	if q, err := ParseKeyword(""); q != nil || err != nil {
+		t.Fatalf("expect no query for an empty keyword, but get %#v, %v", q, err)
+	}
+
+	q, err := ParseKeyword(map[string]interface{}{"age": 30, "tags": []interface{}{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMatcher(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m(map[string]interface{}{"age": float64(30), "tags": []interface{}{"a"}}) {
+		t.Fatal("expect the keyword map to match")
+	}
+
+	q, err = ParseKeyword(map[string]interface{}{
+		"age":   map[string]interface{}{"gt": 18, "lte": 30},
+		"match": map[string]interface{}{"query": "x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clauses := q.(*AndQuery).Queries
+	var ranges, raws int
+	for _, clause := range clauses {
+		switch c := clause.(type) {
+		case *RangeQuery:
+			ranges++
+			if c.Field != "age" || c.Gt != 18 || c.Lte != 30 {
+				t.Fatalf("expect the age range, but get %#v", c)
+			}
+		case *RawQuery:
+			raws++
+		}
+	}
+	if ranges != 1 || raws != 1 {
+		t.Fatalf("expect a range and a raw clause, but get %#v", clauses)
+	}
+
+	if _, err := ParseKeyword(42); !IsBadRequest(err) {
+		t.Fatalf("expect BadRequest error, but get %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// SetResourceVersion injects the resource version v into out. Maps receive it
+// under the "_version" key, structs through a ResourceVersion field of kind
+// int, int64 or string; anything else, including maps whose values cannot
+// hold an int64, is left untouched.
+func SetResourceVersion(out interface{}, v int64) {
+	if out == nil {
+		return
+	}
+
+	switch reflect.TypeOf(out).Kind() {
+	case reflect.Ptr:
+		if reflect.ValueOf(out).IsNil() {
+			return
+		}
+		v_typ := reflect.TypeOf(out).Elem()
+		if v_typ.Kind() == reflect.Map {
+			setMapVersion(reflect.ValueOf(out).Elem(), v)
+		} else if v_typ.Kind() == reflect.Struct {
+			_, ok := v_typ.FieldByName("ResourceVersion")
+			if ok {
+				version_v := reflect.ValueOf(out).Elem().FieldByName("ResourceVersion")
+				if version_v.Kind() == reflect.Int64 || version_v.Kind() == reflect.Int {
+					version_v.SetInt(v)
+				} else if version_v.Kind() == reflect.String {
+					version_v.SetString(fmt.Sprintf("%d", v))
+				}
+			}
+		}
+	case reflect.Map:
+		setMapVersion(reflect.ValueOf(out), v)
+	default:
+
+	}
+}
+
+func setMapVersion(m reflect.Value, v int64) {
+	if m.IsNil() || m.Type().Key().Kind() != reflect.String {
+		return
+	}
+
+	version_v := reflect.ValueOf(v)
+	if !version_v.Type().AssignableTo(m.Type().Elem()) {
+		return
+	}
+	m.SetMapIndex(reflect.ValueOf("_version").Convert(m.Type().Key()), version_v)
+}
+
+// GetResourceVersion returns the resource version injected into obj by
+// SetResourceVersion, or 0 when obj carries none.
+func GetResourceVersion(obj interface{}) int64 {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+
+	var version_v reflect.Value
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return 0
+		}
+		version_v = v.MapIndex(reflect.ValueOf("_version").Convert(v.Type().Key()))
+	case reflect.Struct:
+		version_v = v.FieldByName("ResourceVersion")
+	}
+	for version_v.IsValid() && version_v.Kind() == reflect.Interface {
+		version_v = version_v.Elem()
+	}
+	if !version_v.IsValid() {
+		return 0
+	}
+
+	switch version_v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return version_v.Int()
+	case reflect.Float64:
+		return int64(version_v.Float())
+	case reflect.String:
+		rv, _ := strconv.ParseInt(version_v.String(), 10, 64)
+		return rv
+	}
+	return 0
+}
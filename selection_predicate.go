@@ -1,13 +1,60 @@
 package storage
 
 type SelectionPredicate struct {
+	// Keyword selects the listed objects, a Query or one of the legacy
+	// keyword forms accepted by ParseKeyword.
 	Keyword interface{}
 	Limit   int
 	From    int
 
+	// Includes and Excludes select the fields of the listed objects, see
+	// Projection.
+	Includes []string
+	Excludes []string
+
+	// Sort orders the listed objects by the given fields, the first field
+	// taking precedence. Backends that cannot sort reject it with BadRequest.
+	Sort []SortField
+
 	ScrollKeepAlive string
 	ScrollId        string
 	EOF             bool
 
+	// SearchAfter pages through the listing with an opaque Cursor instead of
+	// From or a scroll, so that deep pages keep no state in the backend. The
+	// first page is listed with an empty Cursor, every call stores the cursor
+	// of the next page in Cursor and sets EOF after the last page. Objects
+	// are ordered by Sort with the object id as tiebreaker.
+	SearchAfter bool
+	Cursor      string
+
 	KeyOnly bool
+
+	// SkipFailed drops the listed objects that could not be fetched or
+	// decoded from the result instead of leaving nil entries in their
+	// place. The failures are reported by a *ListError either way.
+	SkipFailed bool
+
+	// Delimiter lists a single level of a hierarchical key space: only the
+	// objects directly below the key are listed, see DirLister. List cannot
+	// return the deeper levels and rejects it with BadRequest.
+	Delimiter string
+}
+
+const (
+	// SortMissingFirst and SortMissingLast are the values of
+	// SortField.Missing placing objects without the field first or last.
+	SortMissingFirst = "_first"
+	SortMissingLast  = "_last"
+)
+
+// SortField is one sort criterion of a SelectionPredicate.
+type SortField struct {
+	// Field is the dotted path of the document field, such as "user.name".
+	Field      string
+	Descending bool
+
+	// Missing places the objects lacking Field, either SortMissingFirst,
+	// SortMissingLast or a value they are sorted as. Nil sorts them last.
+	Missing interface{}
 }
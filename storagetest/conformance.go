@@ -0,0 +1,1012 @@
+// Package storagetest provides a conformance suite that every
+// storage.Interface backend is expected to pass.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bingbaba/storage"
+)
+
+const (
+	// eventuallyTimeout bounds how long the suite waits for writes to become
+	// visible on backends with near-real-time search, such as elasticsearch.
+	eventuallyTimeout = 5 * time.Second
+	eventuallyTick    = 200 * time.Millisecond
+
+	// watchTimeout bounds how long the suite waits for a watch event, which
+	// polling watchers only deliver after their poll interval.
+	watchTimeout = 30 * time.Second
+)
+
+// Object is the document type written by the suite.
+type Object struct {
+	Name            string `json:"name"`
+	Group           string `json:"group"`
+	Count           int    `json:"count"`
+	ResourceVersion int64  `json:"-"`
+}
+
+// Options relaxes the suite for backends that cannot meet every part of the
+// contract.
+type Options struct {
+	// UnorderedResourceVersions accepts resource versions that change on
+	// every write without increasing, such as ones derived from a content
+	// hash. Callers of such backends cannot order writes by version.
+	UnorderedResourceVersions bool
+}
+
+// RunConformance runs the conformance suite against the stores returned by
+// factory. Every subtest gets a fresh store from factory and writes below its
+// own random key prefix of the form "/storagetest-<n>/object", so the suite
+// can run against shared live backends.
+func RunConformance(t *testing.T, factory func() storage.Interface) {
+	RunConformanceWithOptions(t, factory, Options{})
+}
+
+// RunConformanceWithOptions is RunConformance with parts of the contract
+// relaxed by opts.
+func RunConformanceWithOptions(t *testing.T, factory func() storage.Interface, opts Options) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Interface, prefix string)
+	}{
+		{"CreateGet", testCreateGet},
+		{"CreateExists", testCreateExists},
+		{"GetNotFound", testGetNotFound},
+		{"Update", func(t *testing.T, s storage.Interface, prefix string) { testUpdate(t, s, prefix, opts) }},
+		{"UpdateConflict", testUpdateConflict},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"Upsert", testUpsert},
+		{"Delete", testDelete},
+		{"DeleteByQuery", testDeleteByQuery},
+		{"BulkCreate", testBulkCreate},
+		{"BulkCreateWithOptions", testBulkCreateWithOptions},
+		{"BulkWrite", testBulkWrite},
+		{"GetMany", testGetMany},
+		{"Exists", testExists},
+		{"Count", testCount},
+		{"List", testList},
+		{"ListKeyOnly", testListKeyOnly},
+		{"ListScroll", testListScroll},
+		{"ListQuery", testListQuery},
+		{"ListSort", testListSort},
+		{"ListSearchAfter", testListSearchAfter},
+		{"Projection", testProjection},
+		{"Aggregations", testAggregations},
+		{"TTL", testTTL},
+		{"GuaranteedUpdateTTL", testGuaranteedUpdateTTL},
+		{"Watch", testWatch},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := fmt.Sprintf("/storagetest-%d/object", time.Now().UnixNano())
+			tt.fn(t, factory(), prefix)
+		})
+	}
+}
+
+func testCreateGet(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	key := prefix + "/a"
+
+	if err := s.Create(ctx, key, &Object{Name: "a", Count: 1}, 0); err != nil {
+		t.Fatalf("Create(%s): %v", key, err)
+	}
+
+	var out Object
+	if err := s.Get(ctx, key, &out); err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if out.Name != "a" || out.Count != 1 {
+		t.Fatalf("Get(%s) = %+v, want name \"a\" and count 1", key, out)
+	}
+	if out.ResourceVersion == 0 {
+		t.Errorf("Get(%s) did not set ResourceVersion", key)
+	}
+
+	if err := s.Get(ctx, key, nil); err != nil {
+		t.Errorf("Get(%s) with nil out: %v", key, err)
+	}
+}
+
+func testCreateExists(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	key := prefix + "/a"
+
+	if err := s.Create(ctx, key, &Object{Name: "a"}, 0); err != nil {
+		t.Fatalf("Create(%s): %v", key, err)
+	}
+	err := s.Create(ctx, key, &Object{Name: "b"}, 0)
+	if !storage.IsNodeExist(err) {
+		t.Errorf("second Create(%s) = %v, want a KeyExists error", key, err)
+	}
+
+	var out Object
+	if err := s.Get(ctx, key, &out); err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if out.Name != "a" {
+		t.Errorf("second Create(%s) overwrote the object: %+v", key, out)
+	}
+}
+
+func testGetNotFound(t *testing.T, s storage.Interface, prefix string) {
+	key := prefix + "/missing"
+
+	err := s.Get(context.Background(), key, &Object{})
+	if !storage.IsNotFound(err) {
+		t.Errorf("Get(%s) = %v, want a KeyNotFound error", key, err)
+	}
+}
+
+func testUpdate(t *testing.T, s storage.Interface, prefix string, opts Options) {
+	ctx := context.Background()
+	key := prefix + "/a"
+
+	if err := s.Create(ctx, key, &Object{Name: "a", Group: "g1"}, 0); err != nil {
+		t.Fatalf("Create(%s): %v", key, err)
+	}
+
+	var before Object
+	if err := s.Get(ctx, key, &before); err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+
+	if err := s.Update(ctx, key, before.ResourceVersion, &Object{Name: "a", Group: "g2", Count: 2}, 0); err != nil {
+		t.Fatalf("Update(%s): %v", key, err)
+	}
+
+	var after Object
+	if err := s.Get(ctx, key, &after); err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if after.Group != "g2" || after.Count != 2 {
+		t.Errorf("Get(%s) after Update = %+v, want group \"g2\" and count 2", key, after)
+	}
+	switch {
+	case after.ResourceVersion == 0:
+	case opts.UnorderedResourceVersions:
+		if after.ResourceVersion == before.ResourceVersion {
+			t.Errorf("Update(%s) did not change the resource version %d", key, before.ResourceVersion)
+		}
+	case after.ResourceVersion <= before.ResourceVersion:
+		t.Errorf("Update(%s) did not increase the resource version: %d -> %d",
+			key, before.ResourceVersion, after.ResourceVersion)
+	}
+}
+
+func testUpdateConflict(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	key := prefix + "/a"
+
+	if err := s.Create(ctx, key, &Object{Name: "a"}, 0); err != nil {
+		t.Fatalf("Create(%s): %v", key, err)
+	}
+
+	var stale Object
+	if err := s.Get(ctx, key, &stale); err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if stale.ResourceVersion == 0 {
+		t.Fatalf("Get(%s) did not set ResourceVersion, optimistic concurrency cannot be checked", key)
+	}
+
+	if err := s.Update(ctx, key, stale.ResourceVersion, &Object{Name: "a", Count: 1}, 0); err != nil {
+		t.Fatalf("Update(%s): %v", key, err)
+	}
+	err := s.Update(ctx, key, stale.ResourceVersion, &Object{Name: "a", Count: 2}, 0)
+	if !storage.IsConflict(err) {
+		t.Errorf("Update(%s) with stale resource version = %v, want a ResourceVersionConflicts error", key, err)
+	}
+
+	var out Object
+	if err := s.Get(ctx, key, &out); err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if out.Count != 1 {
+		t.Errorf("Update(%s) with stale resource version was applied: %+v", key, out)
+	}
+}
+
+func testUpdateNotFound(t *testing.T, s storage.Interface, prefix string) {
+	key := prefix + "/missing"
+
+	err := s.Update(context.Background(), key, 0, &Object{Name: "missing"}, 0)
+	if !storage.IsNotFound(err) {
+		t.Errorf("Update(%s) = %v, want a KeyNotFound error", key, err)
+	}
+}
+
+func testUpsert(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	key := prefix + "/a"
+
+	err := s.Upsert(ctx, key, 0, &Object{Name: "a", Count: 2}, &Object{Name: "a", Count: 1}, 0)
+	if err != nil {
+		t.Fatalf("Upsert(%s) of a missing object: %v", key, err)
+	}
+
+	var out Object
+	if err := s.Get(ctx, key, &out); err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if out.Count != 1 {
+		t.Errorf("Upsert(%s) of a missing object stored %+v, want the insert object", key, out)
+	}
+
+	err = s.Upsert(ctx, key, 0, &Object{Name: "a", Count: 2}, &Object{Name: "a", Count: 1}, 0)
+	if err != nil {
+		t.Fatalf("Upsert(%s) of an existing object: %v", key, err)
+	}
+	if err := s.Get(ctx, key, &out); err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	if out.Count != 2 {
+		t.Errorf("Upsert(%s) of an existing object stored %+v, want the update object", key, out)
+	}
+}
+
+func testDelete(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	key := prefix + "/a"
+
+	if err := s.Create(ctx, key, &Object{Name: "a"}, 0); err != nil {
+		t.Fatalf("Create(%s): %v", key, err)
+	}
+	if err := s.Delete(ctx, key, nil); err != nil {
+		t.Fatalf("Delete(%s): %v", key, err)
+	}
+
+	err := s.Get(ctx, key, &Object{})
+	if !storage.IsNotFound(err) {
+		t.Errorf("Get(%s) after Delete = %v, want a KeyNotFound error", key, err)
+	}
+}
+
+func testDeleteByQuery(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	createObjects(t, s, prefix, "a", "b", "c")
+
+	var deleted int64
+	eventually(t, "DeleteByQuery", func() error {
+		n, _, err := s.DeleteByQuery(ctx, prefix, map[string]interface{}{"group": "even"})
+		if err != nil {
+			return err
+		}
+		deleted += n
+		if deleted != 1 {
+			return fmt.Errorf("deleted %d objects, want 1", deleted)
+		}
+		return nil
+	})
+
+	eventually(t, "List after DeleteByQuery", func() error {
+		names, err := listNames(s, prefix, nil)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(names) != "[a c]" {
+			return fmt.Errorf("listed %v, want [a c]", names)
+		}
+		return nil
+	})
+}
+
+func testBulkCreate(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+
+	c := make(chan storage.ChannelObj)
+	go func() {
+		defer close(c)
+		for _, name := range []string{"a", "b", "c"} {
+			c <- storage.ChannelObj{Id: name, Data: &Object{Name: name}}
+		}
+	}()
+	if err := s.BulkCreate(ctx, prefix, c, 0); err != nil {
+		t.Fatalf("BulkCreate(%s): %v", prefix, err)
+	}
+
+	eventually(t, "List after BulkCreate", func() error {
+		names, err := listNames(s, prefix, nil)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(names) != "[a b c]" {
+			return fmt.Errorf("listed %v, want [a b c]", names)
+		}
+		return nil
+	})
+}
+
+func testBulkCreateWithOptions(t *testing.T, s storage.Interface, prefix string) {
+	bc, ok := s.(storage.BulkCreator)
+	if !ok {
+		t.Skip("store does not implement storage.BulkCreator")
+	}
+	ctx := context.Background()
+
+	// an object that cannot be encoded fails on every backend
+	items := []storage.ChannelObj{
+		{Id: "a", Data: &Object{Name: "a"}},
+		{Id: "bad", Data: map[string]interface{}{"c": make(chan int)}},
+		{Id: "b", Data: &Object{Name: "b"}},
+	}
+	produce := func() chan storage.ChannelObj {
+		c := make(chan storage.ChannelObj)
+		go func() {
+			defer close(c)
+			for _, item := range items {
+				c <- item
+			}
+		}()
+		return c
+	}
+
+	results := make(chan storage.BulkResult, len(items))
+	summary, err := bc.BulkCreateWithOptions(ctx, prefix, produce(), 0, storage.BulkOptions{Results: results})
+	if err != nil {
+		t.Fatalf("BulkCreateWithOptions(%s): %v", prefix, err)
+	}
+	if summary.Succeeded != 2 || len(summary.Failed) != 1 || summary.Failed[0].Id != "bad" {
+		t.Errorf("BulkCreateWithOptions(%s) = %+v, want 2 succeeded and \"bad\" failed", prefix, summary)
+	}
+	if len(results) != len(items) {
+		t.Errorf("BulkCreateWithOptions(%s) sent %d results, want %d", prefix, len(results), len(items))
+	}
+	if !storage.IsBulkError(summary.Err()) {
+		t.Errorf("summary error = %v, want a BulkError", summary.Err())
+	}
+
+	summary, err = bc.BulkCreateWithOptions(ctx, prefix+"-stop", produce(), 0, storage.BulkOptions{StopOnError: true})
+	if err != nil {
+		t.Fatalf("BulkCreateWithOptions(%s-stop): %v", prefix, err)
+	}
+	if !summary.Aborted || len(summary.Failed) != 1 {
+		t.Errorf("BulkCreateWithOptions(%s-stop) = %+v, want an aborted summary with one failure", prefix, summary)
+	}
+
+	err = s.BulkCreate(ctx, prefix+"-plain", produce(), 0)
+	if !storage.IsBulkError(err) {
+		t.Errorf("BulkCreate(%s-plain) = %v, want a BulkError", prefix, err)
+	}
+}
+
+func testBulkWrite(t *testing.T, s storage.Interface, prefix string) {
+	bw, ok := s.(storage.BulkWriter)
+	if !ok {
+		t.Skip("store does not implement storage.BulkWriter")
+	}
+	ctx := context.Background()
+	createObjects(t, s, prefix, "a", "b")
+
+	updates := make(chan storage.BulkUpdateObj, 2)
+	updates <- storage.BulkUpdateObj{Id: "a", Data: &Object{Name: "a", Count: 10}}
+	updates <- storage.BulkUpdateObj{Id: "missing", Data: &Object{Name: "missing"}}
+	close(updates)
+	summary, err := bw.BulkUpdate(ctx, prefix, updates, 0, storage.BulkOptions{})
+	if err != nil {
+		t.Fatalf("BulkUpdate(%s): %v", prefix, err)
+	}
+	if summary.Succeeded != 1 || len(summary.Failed) != 1 || !storage.IsNotFound(summary.Failed[0].Err) {
+		t.Errorf("BulkUpdate(%s) = %+v, want one success and one KeyNotFound failure", prefix, summary)
+	}
+	checkCount(t, s, prefix+"/a", 10)
+
+	upserts := make(chan storage.BulkUpdateObj, 2)
+	upserts <- storage.BulkUpdateObj{Id: "b", Data: &Object{Name: "b", Count: 20}}
+	upserts <- storage.BulkUpdateObj{Id: "c", Data: &Object{Name: "c", Count: 0}, Insert: &Object{Name: "c", Count: 30}}
+	close(upserts)
+	summary, err = bw.BulkUpsert(ctx, prefix, upserts, 0, storage.BulkOptions{})
+	if err != nil {
+		t.Fatalf("BulkUpsert(%s): %v", prefix, err)
+	}
+	if summary.Succeeded != 2 {
+		t.Errorf("BulkUpsert(%s) = %+v, want two successes", prefix, summary)
+	}
+	checkCount(t, s, prefix+"/b", 20)
+	checkCount(t, s, prefix+"/c", 30)
+
+	ids := make(chan string, 3)
+	ids <- "a"
+	ids <- "b"
+	ids <- "c"
+	close(ids)
+	summary, err = bw.BulkDelete(ctx, prefix, ids, storage.BulkOptions{})
+	if err != nil {
+		t.Fatalf("BulkDelete(%s): %v", prefix, err)
+	}
+	if summary.Succeeded != 3 {
+		t.Errorf("BulkDelete(%s) = %+v, want three successes", prefix, summary)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := s.Get(ctx, prefix+"/"+name, &Object{}); !storage.IsNotFound(err) {
+			t.Errorf("Get(%s/%s) after BulkDelete = %v, want a KeyNotFound error", prefix, name, err)
+		}
+	}
+}
+
+func testGetMany(t *testing.T, s storage.Interface, prefix string) {
+	mg, ok := s.(storage.MultiGetter)
+	if !ok {
+		t.Skip("store does not implement storage.MultiGetter")
+	}
+	createObjects(t, s, prefix, "a", "b")
+
+	keys := []string{prefix + "/a", prefix + "/b", prefix + "/missing"}
+	objs, errs := mg.GetMany(context.Background(), keys, func() interface{} { return &Object{} })
+	if len(objs) != 2 || len(errs) != 1 {
+		t.Fatalf("GetMany(%v) returned %d objects and %d errors, want 2 and 1", keys, len(objs), len(errs))
+	}
+	for _, name := range []string{"a", "b"} {
+		obj, ok := objs[prefix+"/"+name].(*Object)
+		if !ok || obj.Name != name {
+			t.Errorf("GetMany returned %#v for %s/%s", objs[prefix+"/"+name], prefix, name)
+		}
+	}
+	if err := errs[prefix+"/missing"]; !storage.IsNotFound(err) {
+		t.Errorf("GetMany returned %v for %s/missing, want a KeyNotFound error", err, prefix)
+	}
+}
+
+func testExists(t *testing.T, s storage.Interface, prefix string) {
+	ex, ok := s.(storage.Exister)
+	if !ok {
+		t.Skip("store does not implement storage.Exister")
+	}
+	createObjects(t, s, prefix, "a")
+
+	for key, want := range map[string]bool{prefix + "/a": true, prefix + "/missing": false} {
+		found, err := ex.Exists(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Exists(%s): %v", key, err)
+		}
+		if found != want {
+			t.Errorf("Exists(%s) = %v, want %v", key, found, want)
+		}
+	}
+}
+
+func testCount(t *testing.T, s storage.Interface, prefix string) {
+	c, ok := s.(storage.Counter)
+	if !ok {
+		t.Skip("store does not implement storage.Counter")
+	}
+	createObjects(t, s, prefix, "a", "b", "c", "d")
+
+	eventually(t, "Count", func() error {
+		count, err := c.Count(context.Background(), prefix, nil)
+		if err != nil {
+			return err
+		}
+		if count != 4 {
+			return fmt.Errorf("counted %d objects, want 4", count)
+		}
+		return nil
+	})
+
+	sp := &storage.SelectionPredicate{Keyword: "group:even"}
+	if _, err := c.Count(context.Background(), prefix, sp); storage.IsBadRequest(err) {
+		t.Skip("store cannot count by keyword")
+	}
+	eventually(t, "Count by keyword", func() error {
+		count, err := c.Count(context.Background(), prefix, sp)
+		if err != nil {
+			return err
+		}
+		if count != 2 {
+			return fmt.Errorf("counted %d objects of group even, want 2", count)
+		}
+		return nil
+	})
+}
+
+func checkCount(t *testing.T, s storage.Interface, key string, count int) {
+	t.Helper()
+
+	var out Object
+	if err := s.Get(context.Background(), key, &out); err != nil {
+		t.Errorf("Get(%s): %v", key, err)
+		return
+	}
+	if out.Count != count {
+		t.Errorf("Get(%s) = %+v, want count %d", key, out, count)
+	}
+}
+
+func testList(t *testing.T, s storage.Interface, prefix string) {
+	createObjects(t, s, prefix, "a", "b", "c", "d")
+
+	eventually(t, "List", func() error {
+		names, err := listNames(s, prefix, nil)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(names) != "[a b c d]" {
+			return fmt.Errorf("listed %v, want [a b c d]", names)
+		}
+		return nil
+	})
+
+	eventually(t, "List with keyword", func() error {
+		names, err := listNames(s, prefix, &storage.SelectionPredicate{
+			Keyword: map[string]interface{}{"group": "even"},
+		})
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(names) != "[b d]" {
+			return fmt.Errorf("listed %v, want [b d]", names)
+		}
+		return nil
+	})
+
+	eventually(t, "List with limit", func() error {
+		names, err := listNames(s, prefix, &storage.SelectionPredicate{Limit: 2})
+		if err != nil {
+			return err
+		}
+		if len(names) != 2 {
+			return fmt.Errorf("listed %v, want 2 objects", names)
+		}
+		return nil
+	})
+
+	_, err := s.List(context.Background(), prefix, nil, Object{})
+	if !storage.IsBadRequest(err) {
+		t.Errorf("List(%s) with non-pointer obj = %v, want a BadRequest error", prefix, err)
+	}
+}
+
+func testListKeyOnly(t *testing.T, s storage.Interface, prefix string) {
+	createObjects(t, s, prefix, "a", "b")
+
+	eventually(t, "List with KeyOnly", func() error {
+		list, err := s.List(context.Background(), prefix, &storage.SelectionPredicate{KeyOnly: true}, &Object{})
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(list))
+		for _, item := range list {
+			key, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("listed %T, want string keys", item)
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) != 2 {
+			return fmt.Errorf("listed keys %v, want 2 keys", keys)
+		}
+		return nil
+	})
+}
+
+func testListScroll(t *testing.T, s storage.Interface, prefix string) {
+	createObjects(t, s, prefix, "a", "b", "c", "d", "e")
+
+	eventually(t, "List by scroll", func() error {
+		sp := &storage.SelectionPredicate{ScrollKeepAlive: "1m", Limit: 2}
+		names := make([]string, 0)
+		for i := 0; !sp.EOF; i++ {
+			if i > 10 {
+				return fmt.Errorf("scroll did not reach EOF, listed %v", names)
+			}
+
+			list, err := s.List(context.Background(), prefix, sp, &Object{})
+			if err != nil {
+				return err
+			}
+			if len(list) > sp.Limit {
+				return fmt.Errorf("scroll page has %d objects, want at most %d", len(list), sp.Limit)
+			}
+			for _, item := range list {
+				names = append(names, item.(*Object).Name)
+			}
+		}
+		sort.Strings(names)
+		if fmt.Sprint(names) != "[a b c d e]" {
+			return fmt.Errorf("scrolled %v, want [a b c d e]", names)
+		}
+		if sp.ScrollId != "" {
+			return fmt.Errorf("ScrollId %q left set at EOF", sp.ScrollId)
+		}
+
+		_, err := s.List(context.Background(), prefix, sp, &Object{})
+		if err != io.EOF {
+			return fmt.Errorf("List after EOF = %v, want io.EOF", err)
+		}
+		return nil
+	})
+}
+
+func testListQuery(t *testing.T, s storage.Interface, prefix string) {
+	createObjects(t, s, prefix, "a", "b", "c", "d")
+
+	for _, tt := range []struct {
+		q    interface{}
+		want string
+	}{
+		{storage.Eq("group", "even"), "[b d]"},
+		{storage.In("count", 1, 4), "[a d]"},
+		{storage.Range("count").GreaterThan(1).LessOrEqual(3), "[b c]"},
+		{storage.Or(storage.Eq("group", "even"), storage.Range("count").GreaterOrEqual(3)), "[b c d]"},
+		{storage.And(storage.Not(storage.Eq("group", "even")), storage.Range("count").GreaterThan(1)), "[c]"},
+		{storage.Prefix("name", "b"), "[b]"},
+		{storage.Exists("group"), "[a b c d]"},
+		{storage.Match("group", "odd"), "[a c]"},
+		{map[string]interface{}{"count": map[string]interface{}{"gt": 1, "lte": 3}}, "[b c]"},
+	} {
+		eventually(t, "List by query", func() error {
+			names, err := listNames(s, prefix, &storage.SelectionPredicate{Keyword: tt.q})
+			if err != nil {
+				return err
+			}
+			if fmt.Sprint(names) != tt.want {
+				return fmt.Errorf("listed %v with %#v, want %s", names, tt.q, tt.want)
+			}
+			return nil
+		})
+	}
+}
+
+func testListSort(t *testing.T, s storage.Interface, prefix string) {
+	createObjects(t, s, prefix, "a", "b", "c", "d")
+
+	sp := &storage.SelectionPredicate{Sort: []storage.SortField{{Field: "count", Descending: true}}}
+	if _, err := s.List(context.Background(), prefix, sp, &Object{}); storage.IsBadRequest(err) {
+		t.Skip("store cannot sort")
+	}
+
+	for _, tt := range []struct {
+		sp   *storage.SelectionPredicate
+		want string
+	}{
+		{sp, "[d c b a]"},
+		{&storage.SelectionPredicate{Sort: []storage.SortField{{Field: "count"}}, Limit: 2}, "[a b]"},
+		{&storage.SelectionPredicate{Sort: []storage.SortField{{Field: "count", Descending: true}}, From: 1, Limit: 2}, "[c b]"},
+	} {
+		eventually(t, "List sorted", func() error {
+			list, err := s.List(context.Background(), prefix, tt.sp, &Object{})
+			if err != nil {
+				return err
+			}
+			names, err := objectNames(list)
+			if err != nil {
+				return err
+			}
+			if fmt.Sprint(names) != tt.want {
+				return fmt.Errorf("listed %v with %+v, want %s", names, tt.sp, tt.want)
+			}
+			return nil
+		})
+	}
+}
+
+func testListSearchAfter(t *testing.T, s storage.Interface, prefix string) {
+	createObjects(t, s, prefix, "a", "b", "c", "d", "e")
+
+	_, err := s.List(context.Background(), prefix, &storage.SelectionPredicate{SearchAfter: true}, &Object{})
+	if storage.IsBadRequest(err) {
+		t.Skip("store does not support cursor paging")
+	}
+
+	for _, tt := range []struct {
+		sort []storage.SortField
+		want string
+	}{
+		{nil, "[a b c d e]"},
+		{[]storage.SortField{{Field: "count", Descending: true}}, "[e d c b a]"},
+	} {
+		eventually(t, "List by cursor", func() error {
+			sp := &storage.SelectionPredicate{SearchAfter: true, Sort: tt.sort, Limit: 2}
+			names := make([]string, 0)
+			for i := 0; !sp.EOF; i++ {
+				if i > 5 {
+					return fmt.Errorf("cursor paging did not reach EOF after %d pages", i)
+				}
+				list, err := s.List(context.Background(), prefix, sp, &Object{})
+				if err != nil {
+					return err
+				}
+				page, err := objectNames(list)
+				if err != nil {
+					return err
+				}
+				names = append(names, page...)
+			}
+			if fmt.Sprint(names) != tt.want {
+				return fmt.Errorf("paged %v with sort %+v, want %s", names, tt.sort, tt.want)
+			}
+
+			if _, err := s.List(context.Background(), prefix, sp, &Object{}); err != io.EOF {
+				return fmt.Errorf("List after EOF = %v, want io.EOF", err)
+			}
+			return nil
+		})
+	}
+}
+
+func testProjection(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	createObjects(t, s, prefix, "a", "b")
+
+	var out Object
+	key := prefix + "/b"
+	if err := s.Get(storage.WithProjection(ctx, storage.Projection{Includes: []string{"name"}}), key, &out); err != nil {
+		t.Fatalf("Get(%s) with includes: %v", key, err)
+	}
+	if out.Name != "b" || out.Group != "" || out.Count != 0 {
+		t.Errorf("Get(%s) including the name = %+v, want the name only", key, out)
+	}
+
+	sp := &storage.SelectionPredicate{Excludes: []string{"count"}}
+	eventually(t, "List with excludes", func() error {
+		list, err := s.List(ctx, prefix, sp, &Object{})
+		if err != nil {
+			return err
+		}
+		if len(list) != 2 {
+			return fmt.Errorf("listed %d objects, want 2", len(list))
+		}
+		for _, item := range list {
+			obj, ok := item.(*Object)
+			if !ok || obj == nil {
+				return fmt.Errorf("listed %#v, want *Object", item)
+			}
+			if obj.Name == "" || obj.Group == "" || obj.Count != 0 {
+				return fmt.Errorf("listed %+v excluding the count", obj)
+			}
+		}
+		return nil
+	})
+}
+
+func testAggregations(t *testing.T, s storage.Interface, prefix string) {
+	ag, ok := s.(storage.Aggregator)
+	if !ok {
+		t.Skip("store does not implement storage.Aggregator")
+	}
+	createObjects(t, s, prefix, "a", "b", "c", "d")
+
+	aggs := map[string]*storage.Aggregation{
+		"counts": storage.TermsAgg("count", 2).Sub("max", storage.MaxAgg("count")),
+		"sum":    storage.SumAgg("count"),
+		"avg":    storage.AvgAgg("count"),
+		"unique": storage.CardinalityAgg("count"),
+	}
+	sp := &storage.SelectionPredicate{Keyword: storage.Range("count").GreaterThan(0)}
+	eventually(t, "ListWithAggregations", func() error {
+		list, results, err := ag.ListWithAggregations(context.Background(), prefix, sp, nil, aggs)
+		if err != nil {
+			return err
+		}
+		if len(list) != 0 {
+			return fmt.Errorf("listed %d objects without an object type, want none", len(list))
+		}
+
+		for name, want := range map[string]float64{"sum": 10, "avg": 2.5, "unique": 4} {
+			if r := results[name]; r == nil || r.Value == nil || *r.Value != want {
+				return fmt.Errorf("aggregation %s = %+v, want %v", name, r, want)
+			}
+		}
+
+		counts := results["counts"]
+		if counts == nil || len(counts.Buckets) != 2 {
+			return fmt.Errorf("aggregation counts = %+v, want 2 buckets", counts)
+		}
+		for i, b := range counts.Buckets {
+			key := fmt.Sprint(b.Key)
+			max := b.Aggregations["max"]
+			if key != fmt.Sprint(i+1) || b.DocCount != 1 || max == nil || max.Value == nil || *max.Value != float64(i+1) {
+				return fmt.Errorf("bucket %d of counts = %+v, want key %d with one object", i, b, i+1)
+			}
+		}
+		return nil
+	})
+
+	list, _, err := ag.ListWithAggregations(context.Background(), prefix, &storage.SelectionPredicate{Limit: 1}, &Object{}, aggs)
+	if err != nil {
+		t.Fatalf("ListWithAggregations with objects: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("ListWithAggregations with limit 1 listed %d objects", len(list))
+	}
+}
+
+func testTTL(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	key := prefix + "/a"
+
+	if err := s.Create(ctx, key, &Object{Name: "a"}, 1); err != nil {
+		t.Fatalf("Create(%s): %v", key, err)
+	}
+	if err := s.Get(ctx, key, &Object{}); err != nil {
+		t.Fatalf("Get(%s) before expiry: %v", key, err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	eventually(t, "Get after expiry", func() error {
+		err := s.Get(ctx, key, &Object{})
+		if !storage.IsNotFound(err) {
+			return fmt.Errorf("Get(%s) = %v, want a KeyNotFound error", key, err)
+		}
+		return nil
+	})
+
+	eventually(t, "List after expiry", func() error {
+		names, err := listNames(s, prefix, nil)
+		if err != nil {
+			return err
+		}
+		if len(names) != 0 {
+			return fmt.Errorf("listed %v, want no objects", names)
+		}
+		return nil
+	})
+}
+
+func testGuaranteedUpdateTTL(t *testing.T, s storage.Interface, prefix string) {
+	ctx := context.Background()
+	key := prefix + "/a"
+
+	if err := s.Create(ctx, key, &Object{Name: "a"}, 2); err != nil {
+		t.Fatalf("Create(%s): %v", key, err)
+	}
+	out := &Object{}
+	err := storage.GuaranteedUpdate(ctx, s, key, out, func(current interface{}) (interface{}, error) {
+		obj := *current.(*Object)
+		obj.Count++
+		return &obj, nil
+	})
+	if err != nil {
+		t.Fatalf("GuaranteedUpdate(%s): %v", key, err)
+	}
+	if out.Count != 1 {
+		t.Fatalf("GuaranteedUpdate(%s) stored count %d, want 1", key, out.Count)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+	eventually(t, "Get after expiry", func() error {
+		err := s.Get(ctx, key, &Object{})
+		if !storage.IsNotFound(err) {
+			return fmt.Errorf("Get(%s) = %v, want a KeyNotFound error", key, err)
+		}
+		return nil
+	})
+}
+
+func testWatch(t *testing.T, s storage.Interface, prefix string) {
+	w, ok := s.(storage.Watcher)
+	if !ok {
+		t.Skip("store does not implement storage.Watcher")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key := prefix + "/a"
+
+	c, err := w.Watch(ctx, prefix, nil, 0)
+	if err != nil {
+		t.Fatalf("Watch(%s): %v", prefix, err)
+	}
+
+	if err := s.Create(ctx, key, &Object{Name: "a"}, 0); err != nil {
+		t.Fatalf("Create(%s): %v", key, err)
+	}
+	e := nextEvent(t, c, storage.Added)
+	var obj Object
+	if err := e.Decode(&obj); err != nil {
+		t.Fatalf("decode %s event: %v", e.Type, err)
+	}
+	if e.Key != key || obj.Name != "a" {
+		t.Errorf("%s event for %s with %+v, want key %s and name \"a\"", e.Type, e.Key, obj, key)
+	}
+
+	if err := s.Update(ctx, key, 0, &Object{Name: "a", Count: 1}, 0); err != nil {
+		t.Fatalf("Update(%s): %v", key, err)
+	}
+	nextEvent(t, c, storage.Modified)
+
+	if err := s.Delete(ctx, key, nil); err != nil {
+		t.Fatalf("Delete(%s): %v", key, err)
+	}
+	nextEvent(t, c, storage.Deleted)
+
+	cancel()
+	for range c {
+	}
+}
+
+// nextEvent waits for the next non-error event on c and checks its type.
+func nextEvent(t *testing.T, c <-chan storage.WatchEvent, typ storage.EventType) storage.WatchEvent {
+	t.Helper()
+
+	timeout := time.After(watchTimeout)
+	for {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				t.Fatalf("watch channel closed, want a %s event", typ)
+			}
+			if e.Type == storage.Error {
+				continue
+			}
+			if e.Type != typ {
+				t.Fatalf("got a %s event for %s, want a %s event", e.Type, e.Key, typ)
+			}
+			return e
+		case <-timeout:
+			t.Fatalf("no %s event within %s", typ, watchTimeout)
+		}
+	}
+}
+
+// createObjects creates one object per name below prefix. The second, fourth,
+// ... objects are in group "even", the others in group "odd".
+func createObjects(t *testing.T, s storage.Interface, prefix string, names ...string) {
+	for i, name := range names {
+		group := "odd"
+		if i%2 == 1 {
+			group = "even"
+		}
+
+		key := prefix + "/" + name
+		err := s.Create(context.Background(), key, &Object{Name: name, Group: group, Count: i + 1}, 0)
+		if err != nil {
+			t.Fatalf("Create(%s): %v", key, err)
+		}
+	}
+}
+
+func listNames(s storage.Interface, prefix string, sp *storage.SelectionPredicate) ([]string, error) {
+	list, err := s.List(context.Background(), prefix, sp, &Object{})
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := objectNames(list)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// objectNames returns the names of the listed objects in list order.
+func objectNames(list []interface{}) ([]string, error) {
+	names := make([]string, 0, len(list))
+	for _, item := range list {
+		obj, ok := item.(*Object)
+		if !ok || obj == nil {
+			return nil, fmt.Errorf("listed %#v, want *Object", item)
+		}
+		names = append(names, obj.Name)
+	}
+	return names, nil
+}
+
+// eventually retries fn until it succeeds or eventuallyTimeout elapses.
+func eventually(t *testing.T, what string, fn func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(eventuallyTimeout)
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("%s: %v", what, err)
+			return
+		}
+		time.Sleep(eventuallyTick)
+	}
+}
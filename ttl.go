@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// TTLReader is implemented by backends that report the expiry of stored
+// objects, so that rewriting an object can keep its remaining ttl.
+type TTLReader interface {
+	// TTL returns the seconds until the object stored under key expires,
+	// or zero when it never expires.
+	TTL(ctx context.Context, key string) (uint64, error)
+}
+
+// RemainingTTL converts the expiry time expireAt to a ttl in seconds. The
+// ttl is rounded up, so that an object about to expire does not become
+// permanent. The zero time gives zero.
+func RemainingTTL(expireAt time.Time) uint64 {
+	if expireAt.IsZero() {
+		return 0
+	}
+
+	d := time.Until(expireAt)
+	if d <= 0 {
+		return 1
+	}
+	return uint64((d + time.Second - 1) / time.Second)
+}
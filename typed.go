@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Typed wraps an Interface for objects of type T, so that callers get *T
+// values back instead of interface{} values to assert.
+type Typed[T any] struct {
+	store Interface
+}
+
+func NewTyped[T any](s Interface) *Typed[T] {
+	return &Typed[T]{store: s}
+}
+
+// Store returns the wrapped untyped store.
+func (t *Typed[T]) Store() Interface {
+	return t.store
+}
+
+func (t *Typed[T]) Get(ctx context.Context, key string) (*T, error) {
+	out := new(T)
+	if err := t.store.Get(ctx, key, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *Typed[T]) Create(ctx context.Context, key string, obj *T, ttl uint64) error {
+	return t.store.Create(ctx, key, obj, ttl)
+}
+
+func (t *Typed[T]) Update(ctx context.Context, key string, resourceVersion int64, obj *T, ttl uint64) error {
+	return t.store.Update(ctx, key, resourceVersion, obj, ttl)
+}
+
+// Upsert passes a nil insert_obj on as an untyped nil, so that backends fall
+// back to update_obj.
+func (t *Typed[T]) Upsert(ctx context.Context, key string, resourceVersion int64, update_obj, insert_obj *T, ttl uint64) error {
+	if insert_obj == nil {
+		return t.store.Upsert(ctx, key, resourceVersion, update_obj, nil, ttl)
+	}
+	return t.store.Upsert(ctx, key, resourceVersion, update_obj, insert_obj, ttl)
+}
+
+func (t *Typed[T]) Delete(ctx context.Context, key string) error {
+	return t.store.Delete(ctx, key, nil)
+}
+
+// List lists objects of type T. Entries the backend could not load are nil.
+// KeyOnly predicates are rejected, since keys are not of type T.
+func (t *Typed[T]) List(ctx context.Context, key string, sp *SelectionPredicate) ([]*T, error) {
+	if sp != nil && sp.KeyOnly {
+		return nil, NewBadRequestError("KeyOnly is not supported by a typed list")
+	}
+
+	list, err := t.store.List(ctx, key, sp, new(T))
+	objs := make([]*T, len(list))
+	for i, item := range list {
+		if item == nil {
+			continue
+		}
+		obj, ok := item.(*T)
+		if !ok {
+			return objs, NewInvalidObjError(key, fmt.Sprintf("listed %T, want %T", item, obj))
+		}
+		objs[i] = obj
+	}
+	return objs, err
+}
+
+// GuaranteedUpdate is the typed form of the package level GuaranteedUpdate
+// and returns the stored object.
+func (t *Typed[T]) GuaranteedUpdate(ctx context.Context, key string, tryUpdate func(current *T) (*T, error)) (*T, error) {
+	out := new(T)
+	err := GuaranteedUpdate(ctx, t.store, key, out, func(current interface{}) (interface{}, error) {
+		return tryUpdate(current.(*T))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,56 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bingbaba/storage"
+	"github.com/bingbaba/storage/memory"
+)
+
+type user struct {
+	Name            string `json:"name"`
+	ResourceVersion int64  `json:"-"`
+}
+
+func TestTyped(t *testing.T) {
+	users := storage.NewTyped[user](memory.NewStore())
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b"} {
+		if err := users.Create(ctx, "/users/"+name, &user{Name: name}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	u, err := users.Get(ctx, "/users/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "a" || u.ResourceVersion == 0 {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+
+	list, err := users.List(ctx, "/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "a" || list[1].Name != "b" {
+		t.Fatalf("unexpected list: %v", list)
+	}
+
+	u, err = users.GuaranteedUpdate(ctx, "/users/b", func(current *user) (*user, error) {
+		return &user{Name: current.Name + "2"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "b2" {
+		t.Fatalf("expect \"b2\", but get \"%s\"", u.Name)
+	}
+
+	_, err = users.List(ctx, "/users", &storage.SelectionPredicate{KeyOnly: true})
+	if !storage.IsBadRequest(err) {
+		t.Fatalf("expect BadRequest error, but get %v", err)
+	}
+}
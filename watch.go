@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type EventType string
+
+const (
+	Added    EventType = "ADDED"
+	Modified EventType = "MODIFIED"
+	Deleted  EventType = "DELETED"
+	Error    EventType = "ERROR"
+)
+
+// WatchEvent describes a change to the object stored under Key. Object holds
+// the encoded object as stored by the backend; for Deleted events it is the
+// last state the watcher saw. Error events carry Err and no object.
+type WatchEvent struct {
+	Type            EventType
+	Key             string
+	ResourceVersion int64
+	Object          []byte
+	Err             error
+}
+
+// Decode unmarshals the event object into out and injects its resource
+// version.
+func (e WatchEvent) Decode(out interface{}) error {
+	if e.Object == nil {
+		return NewInvalidObjError(e.Key, "event carries no object")
+	}
+
+	err := json.Unmarshal(e.Object, out)
+	if err != nil {
+		return NewInvalidObjError(e.Key, err.Error())
+	}
+	SetResourceVersion(out, e.ResourceVersion)
+
+	return nil
+}
+
+// Watcher is implemented by backends that can report changes below a key.
+// With resourceVersion 0 the channel first receives an Added event for every
+// object that currently matches sp, otherwise only later changes are sent.
+// The channel is closed once ctx is done.
+type Watcher interface {
+	Watch(ctx context.Context, key string, sp *SelectionPredicate, resourceVersion int64) (<-chan WatchEvent, error)
+}
+
+// WatchState is the state of one object as seen by a single poll. Tag must
+// change whenever the object changes; when empty the resource version is
+// compared instead. Object may be left nil and loaded on demand.
+type WatchState struct {
+	ResourceVersion int64
+	Tag             string
+	Object          []byte
+}
+
+func (s WatchState) changed(old WatchState) bool {
+	if s.Tag != "" || old.Tag != "" {
+		return s.Tag != old.Tag
+	}
+	return s.ResourceVersion != old.ResourceVersion
+}
+
+// PollWatch implements Watch for backends without change notifications by
+// calling poll every interval and diffing consecutive results. fetch loads
+// the objects of added and modified keys whose state has no Object; it may
+// be nil when poll always returns objects. Keys fetch reports as not found
+// are treated as deleted. Other errors from poll and fetch are reported as
+// Error events and polling continues; keys that failed to load are loaded
+// again on the next poll.
+func PollWatch(ctx context.Context, interval time.Duration, resourceVersion int64,
+	poll func(ctx context.Context) (map[string]WatchState, error),
+	fetch func(ctx context.Context, key string) ([]byte, error)) (<-chan WatchEvent, error) {
+
+	// the first poll is synchronous so that an unreachable backend fails Watch
+	last, err := poll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan WatchEvent)
+	go func() {
+		defer close(c)
+
+		send := func(e WatchEvent) bool {
+			select {
+			case c <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// load reports whether the object of key could be loaded into state.
+		// A key that vanished gives false and no error.
+		load := func(key string, state WatchState) (WatchState, bool, error) {
+			if state.Object != nil || fetch == nil {
+				return state, true, nil
+			}
+			obj, err := fetch(ctx, key)
+			if err != nil {
+				if IsNotFound(err) {
+					err = nil
+				}
+				return state, false, err
+			}
+			state.Object = obj
+			return state, true, nil
+		}
+
+		if resourceVersion == 0 {
+			for key, state := range last {
+				state, ok, err := load(key, state)
+				if !ok {
+					delete(last, key)
+					if err != nil && !send(WatchEvent{Type: Error, Key: key, Err: err}) {
+						return
+					}
+					continue
+				}
+				last[key] = state
+				if !send(WatchEvent{Type: Added, Key: key, ResourceVersion: state.ResourceVersion, Object: state.Object}) {
+					return
+				}
+			}
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			cur, err := poll(ctx)
+			if err != nil {
+				if ctx.Err() != nil || !send(WatchEvent{Type: Error, Err: err}) {
+					return
+				}
+				continue
+			}
+
+			for key, state := range cur {
+				old, ok := last[key]
+				if ok && !state.changed(old) {
+					cur[key] = old
+					continue
+				}
+
+				state, loaded, err := load(key, state)
+				if !loaded {
+					// a vanished key is deleted below, a failed one keeps
+					// its last state until it loads
+					if err != nil && ok {
+						cur[key] = old
+					} else {
+						delete(cur, key)
+					}
+					if err != nil && !send(WatchEvent{Type: Error, Key: key, Err: err}) {
+						return
+					}
+					continue
+				}
+				cur[key] = state
+				typ := Added
+				if ok {
+					typ = Modified
+				}
+				if !send(WatchEvent{Type: typ, Key: key, ResourceVersion: state.ResourceVersion, Object: state.Object}) {
+					return
+				}
+			}
+			for key, old := range last {
+				if _, ok := cur[key]; ok {
+					continue
+				}
+				if !send(WatchEvent{Type: Deleted, Key: key, ResourceVersion: old.ResourceVersion, Object: old.Object}) {
+					return
+				}
+			}
+			last = cur
+		}
+	}()
+
+	return c, nil
+}
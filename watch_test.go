@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPollWatch(t *testing.T) {
+	var mu sync.Mutex
+	states := map[string]WatchState{
+		"/a": {ResourceVersion: 1, Object: []byte(`{"name":"a"}`)},
+	}
+	poll := func(ctx context.Context) (map[string]WatchState, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cur := make(map[string]WatchState, len(states))
+		for k, v := range states {
+			cur[k] = v
+		}
+		return cur, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := PollWatch(ctx, 10*time.Millisecond, 0, poll, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvent(t, c, Added, "/a", 1)
+
+	mu.Lock()
+	states["/a"] = WatchState{ResourceVersion: 2, Object: []byte(`{"name":"a2"}`)}
+	mu.Unlock()
+	e := expectEvent(t, c, Modified, "/a", 2)
+
+	var out map[string]interface{}
+	if err := e.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out["name"] != "a2" || out["_version"] != int64(2) {
+		t.Fatalf("unexpected decoded object: %v", out)
+	}
+
+	mu.Lock()
+	delete(states, "/a")
+	mu.Unlock()
+	expectEvent(t, c, Deleted, "/a", 2)
+
+	cancel()
+	for range c {
+	}
+}
+
+func expectEvent(t *testing.T, c <-chan WatchEvent, typ EventType, key string, rv int64) WatchEvent {
+	t.Helper()
+
+	select {
+	case e := <-c:
+		if e.Type != typ || e.Key != key || e.ResourceVersion != rv {
+			t.Fatalf("expect %s %s@%d, but get %s %s@%d", typ, key, rv, e.Type, e.Key, e.ResourceVersion)
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("expect %s %s@%d, but get nothing", typ, key, rv)
+	}
+	return WatchEvent{}
+}
+
+func TestPollWatchFetch(t *testing.T) {
+	var mu sync.Mutex
+	rv := int64(1)
+	fetchErr := error(nil)
+	poll := func(ctx context.Context) (map[string]WatchState, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return map[string]WatchState{"/a": {ResourceVersion: rv}}, nil
+	}
+	fetch := func(ctx context.Context, key string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return []byte(`{"name":"a"}`), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := PollWatch(ctx, 10*time.Millisecond, 0, poll, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, c, Added, "/a", 1)
+
+	mu.Lock()
+	rv, fetchErr = 2, NewUnreachableError("/a", 0)
+	mu.Unlock()
+	if e := expectEvent(t, c, Error, "/a", 0); !IsUnreachable(e.Err) {
+		t.Fatalf("expect the fetch error, but get %v", e.Err)
+	}
+
+	mu.Lock()
+	fetchErr = nil
+	mu.Unlock()
+	if e := expectEvent(t, c, Modified, "/a", 2); e.Object == nil {
+		t.Fatal("expect the failed key to be loaded again")
+	}
+
+	mu.Lock()
+	rv, fetchErr = 3, NewKeyNotFoundError("/a", 0)
+	mu.Unlock()
+	expectEvent(t, c, Deleted, "/a", 2)
+
+	cancel()
+	for range c {
+	}
+}